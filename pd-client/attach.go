@@ -0,0 +1,102 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"git.cse.iitk.ac.in/ssaha/parallel-debugger/utils"
+)
+
+// Environment variables in which launchers pass the rank and the world size
+// of a process, as pairs of rank and size variables.
+var rankEnvVars = [][2]string{
+	{"OMPI_COMM_WORLD_RANK", "OMPI_COMM_WORLD_SIZE"},
+	{"PMI_RANK", "PMI_SIZE"},
+	{"MV2_COMM_WORLD_RANK", "MV2_COMM_WORLD_SIZE"},
+	{"SLURM_PROCID", "SLURM_NTASKS"},
+}
+
+// attachMain attaches gdb to a rank of a job which was not started under
+// the debugger. The rank and the world size are taken from the flags, or
+// else from the environment of the process, or else by asking MPI in the
+// process itself.
+func attachMain(args []string) {
+	flags := flag.NewFlagSet("attach", flag.ExitOnError)
+	rank := flags.Int("rank", -1, "Rank of the process (default: discovered from the process)")
+	wSize := flags.Int("size", -1, "Number of ranks in the job (default: discovered from the process)")
+	flags.Usage = func() {
+		fmt.Printf("Usage %s attach [options] <hostname>:<port> <pid>\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(1)
+	}
+	pid, err := strconv.Atoi(flags.Arg(1))
+	utils.CheckError(err)
+
+	conn, err := net.Dial("tcp", flags.Arg(0))
+	utils.CheckError(err)
+
+	cInfoChan := make(chan utils.CollectiveInfo)
+	sInfoChan := make(chan utils.StateInfo)
+	debugger, err := utils.NewDebugger(os.Getenv("PD_DEBUGGER"), cInfoChan, sInfoChan)
+	utils.CheckError(err)
+	utils.CheckError(debugger.Attach(pid))
+	log.Printf("GDB attached to %d\n", pid)
+
+	if *rank < 0 || *wSize < 0 {
+		envRank, envSize, ok := rankFromEnviron(pid)
+		if !ok {
+			envRank, envSize, err = debugger.EvaluateRank()
+			utils.CheckError(err)
+		}
+		if *rank < 0 {
+			*rank = envRank
+		}
+		if *wSize < 0 {
+			*wSize = envSize
+		}
+	}
+	log.Printf("Process %d is rank %d of %d\n", pid, *rank, *wSize)
+
+	serve(conn, debugger, *rank, *wSize, cInfoChan, sInfoChan)
+}
+
+// rankFromEnviron looks for the rank and the world size in the environment
+// of a process.
+func rankFromEnviron(pid int) (rank int, wSize int, ok bool) {
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/environ", pid))
+	if err != nil {
+		return -1, -1, false
+	}
+
+	env := make(map[string]string)
+	for _, kv := range strings.Split(string(data), "\x00") {
+		kvSplit := strings.SplitN(kv, "=", 2)
+		if len(kvSplit) == 2 {
+			env[kvSplit[0]] = kvSplit[1]
+		}
+	}
+
+	for _, vars := range rankEnvVars {
+		rank, err := strconv.Atoi(env[vars[0]])
+		if err != nil {
+			continue
+		}
+		wSize, err := strconv.Atoi(env[vars[1]])
+		if err != nil {
+			continue
+		}
+		return rank, wSize, true
+	}
+	return -1, -1, false
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+
+	"git.cse.iitk.ac.in/ssaha/parallel-debugger/utils"
+)
+
+// coreMain loads a core file of one rank instead of running the program, so
+// that the cores left behind by a job can be analyzed together.
+// The rank is taken from -rank, or else from the core file's name. The world
+// size is taken from -size, or else is the number of core files next to this
+// one whose names match the pattern.
+func coreMain(args []string) {
+	flags := flag.NewFlagSet("core", flag.ExitOnError)
+	rank := flags.Int("rank", -1, "Rank of the core file (default: taken from the file name)")
+	wSize := flags.Int("size", -1, "Number of ranks in the job (default: number of matching core files)")
+	pattern := flags.String("pattern", `^core\.(\d+)$`, "Pattern of the core file names, whose first group is the rank")
+	flags.Usage = func() {
+		fmt.Printf("Usage %s core [options] <hostname>:<port> <filename> <corefile>\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 3 {
+		flags.Usage()
+		os.Exit(1)
+	}
+	filename := flags.Arg(1)
+	coreFile := flags.Arg(2)
+
+	re, err := regexp.Compile(*pattern)
+	utils.CheckError(err)
+	if *rank < 0 {
+		*rank = rankFromCoreName(re, coreFile)
+	}
+	if *wSize < 0 {
+		*wSize = countCoreFiles(re, coreFile)
+	}
+	if *rank < 0 || *rank >= *wSize {
+		log.Fatalf("Rank %d is not within a world of size %d, use -rank and -size\n", *rank, *wSize)
+	}
+
+	conn, err := net.Dial("tcp", flags.Arg(0))
+	utils.CheckError(err)
+
+	cInfoChan := make(chan utils.CollectiveInfo)
+	sInfoChan := make(chan utils.StateInfo)
+	debugger, err := utils.NewDebugger(os.Getenv("PD_DEBUGGER"), cInfoChan, sInfoChan)
+	utils.CheckError(err)
+	utils.CheckError(debugger.LoadCore(filename, coreFile))
+	log.Printf("GDB Initialized with core file %s\n", coreFile)
+
+	serve(conn, debugger, *rank, *wSize, cInfoChan, sInfoChan)
+}
+
+// rankFromCoreName extracts the rank from the name of a core file, using the
+// first group of the pattern. It returns -1 if the name does not match.
+func rankFromCoreName(re *regexp.Regexp, coreFile string) int {
+	match := re.FindStringSubmatch(filepath.Base(coreFile))
+	if len(match) < 2 {
+		return -1
+	}
+	rank, err := strconv.Atoi(match[1])
+	if err != nil {
+		return -1
+	}
+	return rank
+}
+
+// countCoreFiles counts the core files in the directory of coreFile whose
+// names match the pattern.
+func countCoreFiles(re *regexp.Regexp, coreFile string) int {
+	files, err := ioutil.ReadDir(filepath.Dir(coreFile))
+	utils.CheckError(err)
+
+	count := 0
+	for _, f := range files {
+		if re.MatchString(f.Name()) {
+			count++
+		}
+	}
+	return count
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bufio"
 	"fmt"
 	"log"
 	"encoding/json"
@@ -13,26 +12,53 @@ import (
 )
 
 func main() {
-	if len(os.Args) != 3 {
-		fmt.Printf("Usage %s <hostname>:<port> <filename>\n", os.Args[0])
+	if len(os.Args) > 1 && os.Args[1] == "core" {
+		coreMain(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "attach" {
+		attachMain(os.Args[2:])
+		return
+	}
+
+	// The server's address is passed through the environment when the
+	// server launched the job itself.
+	address := os.Getenv("PD_SERVER")
+	args := os.Args[1:]
+	if address == "" && len(args) > 0 {
+		address = args[0]
+		args = args[1:]
+	}
+	if address == "" || len(args) < 1 {
+		fmt.Printf("Usage %s <hostname>:<port> <filename> [args...]\n", os.Args[0])
+		fmt.Printf("      %s core [options] <hostname>:<port> <filename> <corefile>\n", os.Args[0])
+		fmt.Printf("      %s attach [options] <hostname>:<port> <pid>\n", os.Args[0])
+		fmt.Printf("The address of the server can be given in PD_SERVER instead.\n")
 		os.Exit(1)
 	}
-	conn, err := net.Dial("tcp", os.Args[1])
+	conn, err := net.Dial("tcp", address)
 	utils.CheckError(err)
-	filename := os.Args[2]
+	filename := args[0]
 
 	cInfoChan := make(chan utils.CollectiveInfo)
-	gdbInstance := utils.NewGdb(cInfoChan)
-
-	pdFilename := gdbInstance.InitGdb(filename)
-
-	f, err := os.Open(pdFilename)
+	sInfoChan := make(chan utils.StateInfo)
+	debugger, err := utils.NewDebugger(os.Getenv("PD_DEBUGGER"), cInfoChan, sInfoChan)
 	utils.CheckError(err)
-	line, err := bufio.NewReader(f).ReadString('\n')
+
+	rank, wSize, err := debugger.RunToInit(filename, args[1:])
 	utils.CheckError(err)
-	fmt.Fprintf(conn, "%s", line)
 	log.Printf("GDB Initialized\n")
 
+	serve(conn, debugger, rank, wSize, cInfoChan, sInfoChan)
+}
+
+// serve introduces the client to the server as rank out of wSize, and then
+// relays messages between the server and the debugger until the server goes
+// away.
+func serve(conn net.Conn, debugger utils.Debugger, rank int, wSize int,
+	cInfoChan chan utils.CollectiveInfo, sInfoChan chan utils.StateInfo) {
+	fmt.Fprintf(conn, "%d,%d\n", rank, wSize)
+
 	// Each time we get some communicator info, send to the server to process.
 	go (func() {
 		var c utils.CollectiveInfo
@@ -46,42 +72,71 @@ func main() {
 		}
 	})()
 
-	// Each output that the gdb instance gets from gdb mi must be processed.
-	// One hook is added here, which will send all ~console messages to the server.
-	gdbInstance.AddNotificationHook("ConsoleSendingHook", func(notification map[string]interface{}) bool {
-		if notification["type"] == "console" {
-			// On getting a console notification, relay it to the server.
+	// Each change in the execution state of the program is reported to the
+	// server, so that it can keep track of where every rank is.
+	go (func() {
+		var s utils.StateInfo
+		for {
+			s = <-sInfoChan
+			s.Rank = rank
+			out, err := json.Marshal(s)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(conn, "STATE:%s\n", out)
+		}
+	})()
+	debugger.ReportState()
+
+	// The server pauses the output of a rank which prints more than it
+	// can show.
+	var flow utils.Flow
+
+	// Each event that the debugger reports must be processed.
+	// One hook is added here, which will send all console messages to the server.
+	debugger.AddEventHook("ConsoleSendingHook", func(e utils.Event) {
+		if e.Kind == utils.ConsoleEvent {
+			// On getting a console event, relay it to the server.
 			// Filter newlines though. They will be added by us at server side!
-			payload := strings.TrimSpace(notification["payload"].(string))
-			if payload == "" || payload == "\n" {
-				return true
+			payload := strings.TrimSpace(e.Text)
+			if payload == "" {
+				return
+			}
+			if flow.Send() {
+				fmt.Fprintf(conn, "CONSOLE:%s\n", payload)
 			}
-			fmt.Fprintf(conn, "CONSOLE:%s\n", payload)
 			fmt.Println(payload)
 		}
-		return true
 	})
 
-	gdbInstance.AddNotificationHook("ErrorSendingHook", func(notification map[string]interface{}) bool {
-		if notification["class"] == "error" {
-			// On getting a error notification, tell the server.
-			payload := notification["payload"].(map[string]interface{})
-			msg := payload["msg"].(string)
-			msg = strings.TrimSpace(msg)
+	debugger.AddEventHook("ErrorSendingHook", func(e utils.Event) {
+		if e.Kind == utils.ErrorEvent {
+			// On getting an error event, tell the server.
+			msg := strings.TrimSpace(e.Text)
 			fmt.Fprintf(conn, "ERROR:%s\n", msg)
 			fmt.Println(msg)
 		}
-		return true
 	})
 
-	gdbInstance.AddNotificationHook("LoggingHook", func(notification map[string]interface{}) bool {
-		jsonStr, _ := json.Marshal(notification)
+	// The breakpoints gdb changes on its own, e.g. pending ones which it
+	// sets once their library is loaded, are reported to the server.
+	debugger.AddEventHook("BreakpointSendingHook", func(e utils.Event) {
+		if e.Kind == utils.BreakpointEvent {
+			out, err := json.Marshal(e.Breakpoint)
+			if err != nil {
+				return
+			}
+			fmt.Fprintf(conn, "BREAKPOINT:%s\n", out)
+		}
+	})
+
+	debugger.AddEventHook("LoggingHook", func(e utils.Event) {
+		jsonStr, _ := json.Marshal(e.Record)
 		log.Println(string(jsonStr))
-		return true
 	})
 
 	// Each message from the server needs to be processed using ProcessMessage.
 	processCommandsDone := make(chan bool)
-	go gdbInstance.ProcessCommands(conn, processCommandsDone)
+	go utils.ProcessCommands(debugger, conn, &flow, processCommandsDone)
 	<-processCommandsDone
 }
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/gdamore/tcell/terminfo"
+	"github.com/kr/pty"
+)
+
+// detachKey detaches the terminal from the session when typed, which is
+// Ctrl+\.
+const detachKey = 0x1c
+
+// attachMain attaches this terminal to a session run in the background with
+// -daemon:
+//
+//	pd-server attach [<pid>]
+//
+// Without a pid it attaches to the only session there is, and otherwise
+// lists them.
+func attachMain(args []string) {
+	flags := flag.NewFlagSet("attach", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Printf("Usage %s attach [<pid>]\n", os.Args[0])
+		fmt.Println("Attaches to a session started with -daemon. Ctrl+\\ or pdb_detach detaches again, and quit ends the session.")
+	}
+	flags.Parse(args)
+
+	pids, err := listSessions()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not list the sessions: %v\n", err)
+		os.Exit(1)
+	}
+	var pid int
+	switch {
+	case flags.NArg() == 1:
+		if pid, err = strconv.Atoi(flags.Arg(0)); err != nil {
+			flags.Usage()
+			os.Exit(1)
+		}
+	case flags.NArg() > 1:
+		flags.Usage()
+		os.Exit(1)
+	case len(pids) == 0:
+		fmt.Fprintln(os.Stderr, "There are no sessions to attach to, start one with pd-server -daemon run")
+		os.Exit(1)
+	case len(pids) == 1:
+		pid = pids[0]
+	default:
+		fmt.Println("There are several sessions, attach to one with pd-server attach <pid>:")
+		for _, pid := range pids {
+			fmt.Printf("  %d  %s\n", pid, sessionCommand(pid))
+		}
+		os.Exit(1)
+	}
+
+	path, err := socketPath(pid)
+	if err == nil {
+		_, err = os.Stat(path)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "There is no session %d\n", pid)
+		os.Exit(1)
+	}
+	os.Exit(attachSession(path, pid))
+}
+
+// listSessions gives the pids of the daemons of the user, and removes the
+// sockets of those which are gone.
+func listSessions() ([]int, error) {
+	dir, err := socketDir()
+	if err != nil {
+		return nil, err
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var pids []int
+	for _, f := range files {
+		pid, err := strconv.Atoi(strings.TrimSuffix(f.Name(), ".sock"))
+		if err != nil || !strings.HasSuffix(f.Name(), ".sock") {
+			continue
+		}
+		if !sessionAlive(filepath.Join(dir, f.Name())) {
+			os.Remove(filepath.Join(dir, f.Name()))
+			continue
+		}
+		pids = append(pids, pid)
+	}
+	sort.Ints(pids)
+	return pids, nil
+}
+
+// sessionAlive tells if the daemon of a socket still takes terminals. One
+// which was killed leaves its socket behind.
+func sessionAlive(path string) bool {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// sessionCommand tells how a daemon was started, where the system tells.
+func sessionCommand(pid int) string {
+	cmdline, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil {
+		return ""
+	}
+	return strings.Join(strings.Split(strings.TrimRight(string(cmdline), "\x00"), "\x00"), " ")
+}
+
+// attachSession attaches this terminal to the session of a daemon until it
+// is detached or the session ends, and gives back the exit status.
+func attachSession(path string, pid int) int {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not attach to session %d: %v\n", pid, err)
+		return 1
+	}
+	defer conn.Close()
+
+	saved, err := stty("-g")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not set up the terminal: %v\n", err)
+		return 1
+	}
+	stty("raw", "-echo")
+
+	var w sync.Mutex
+	send := func(kind byte, payload []byte) {
+		w.Lock()
+		writeFrame(conn, kind, payload)
+		w.Unlock()
+	}
+	sendSize := func() {
+		if rows, cols, err := pty.Getsize(os.Stdin); err == nil {
+			send(frameSize, formatSize(rows, cols))
+		}
+	}
+	sendSize()
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, syscall.SIGWINCH)
+	go func() {
+		for range winch {
+			sendSize()
+		}
+	}()
+
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, err := os.Stdin.Read(buf)
+			if err != nil {
+				conn.Close()
+				return
+			}
+			if i := bytes.IndexByte(buf[:n], detachKey); i >= 0 {
+				send(frameInput, buf[:i])
+				conn.Close()
+				return
+			}
+			send(frameInput, buf[:n])
+		}
+	}()
+	io.Copy(os.Stdout, conn)
+
+	signal.Stop(winch)
+	if ti, err := terminfo.LookupTerminfo(os.Getenv("TERM")); err == nil {
+		io.WriteString(os.Stdout, ti.ExitKeypad+ti.ExitCA+ti.ShowCursor+ti.AttrOff)
+	}
+	stty(saved)
+
+	if !sessionAlive(path) {
+		fmt.Printf("The session %d ended\n", pid)
+	} else {
+		fmt.Printf("Detached from session %d, pd-server attach %d attaches to it again\n", pid, pid)
+	}
+	return 0
+}
+
+// stty changes the mode of the terminal, and gives back what it printed.
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
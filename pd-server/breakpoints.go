@@ -0,0 +1,399 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"git.cse.iitk.ac.in/ssaha/parallel-debugger/pd-server/tui"
+	"git.cse.iitk.ac.in/ssaha/parallel-debugger/utils"
+)
+
+// globalBreakpoint is a breakpoint set with pdb_break. It has the same ID
+// on every rank, while gdb numbers it on its own on every rank.
+type globalBreakpoint struct {
+	id       int
+	location string
+	// installs holds the breakpoint as gdb on every rank knows it, and
+	// failures why it could not be set on a rank.
+	installs map[int]utils.Breakpoint
+	failures map[int]string
+	disabled map[int]bool
+	hits     map[int]int
+}
+
+var breakpoints struct {
+	nextID int
+	byID   map[int]*globalBreakpoint
+	mux    sync.Mutex
+}
+
+func init() {
+	breakpoints.byID = make(map[int]*globalBreakpoint)
+}
+
+// resetBreakpoints forgets the breakpoints of a finished session.
+func resetBreakpoints() {
+	breakpoints.mux.Lock()
+	breakpoints.nextID = 0
+	breakpoints.byID = make(map[int]*globalBreakpoint)
+	breakpoints.mux.Unlock()
+}
+
+// takeBreakpointInput runs the pdb_break family of commands, and tells if
+// input was one of them:
+//
+//	pdb_break <location> [if <condition>] [r=...]
+//	pdb_delete <id> [r=...]
+//	pdb_disable <id> [r=...]
+//	pdb_enable <id> [r=...]
+//	pdb_info_break [id]
+func takeBreakpointInput(input string, t *tui.TUI) bool {
+	if !strings.HasPrefix(input, "pdb_") {
+		return false
+	}
+	command, ranks := parseInput(input)
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return false
+	}
+
+	switch fields[0] {
+	case "break":
+		if len(fields) < 2 {
+			go t.ShowMessagesAll("Usage: pdb_break <location> [if <condition>] [r=...]")
+			return true
+		}
+		go insertBreakpoint(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(command), "break")), ranks, t)
+	case "delete", "disable", "enable":
+		id := 0
+		if len(fields) == 2 {
+			id, _ = strconv.Atoi(fields[1])
+		}
+		if id == 0 {
+			go t.ShowMessagesAll(fmt.Sprintf("Usage: pdb_%s <id> [r=...]", fields[0]))
+			return true
+		}
+		go changeBreakpoint(fields[0], id, ranks, t)
+	case "info_break":
+		id := 0
+		if len(fields) == 2 {
+			id, _ = strconv.Atoi(fields[1])
+		}
+		go prettyPrintBreakpoints(id, t)
+	default:
+		return false
+	}
+	return true
+}
+
+// allRanks gives every connected rank, in order.
+func allRanks() []int {
+	var ranks []int
+	for rank := range connections {
+		ranks = append(ranks, rank)
+	}
+	sort.Ints(ranks)
+	return ranks
+}
+
+// insertBreakpoint sets a new breakpoint on ranks, or on all the ranks if
+// ranks is nil. The location, along with its condition if any, is a
+// template expanded for every rank, e.g. f if i == ${rank*10}.
+func insertBreakpoint(location string, ranks []int, t *tui.TUI) {
+	if ranks == nil {
+		ranks = allRanks()
+	}
+
+	breakpoints.mux.Lock()
+	breakpoints.nextID++
+	b := &globalBreakpoint{
+		id:       breakpoints.nextID,
+		location: location,
+		installs: make(map[int]utils.Breakpoint),
+		failures: make(map[int]string),
+		disabled: make(map[int]bool),
+		hits:     make(map[int]int),
+	}
+	breakpoints.byID[b.id] = b
+	args := make(map[int][]string)
+	for _, rank := range ranks {
+		expanded, err := expandTemplate(location, rank)
+		if err != nil {
+			b.failures[rank] = err.Error()
+			continue
+		}
+		args[rank] = []string{expanded}
+	}
+	breakpoints.mux.Unlock()
+
+	missing := request("break-insert", args, func(rank int, resp utils.Response) {
+		breakpoints.mux.Lock()
+		defer breakpoints.mux.Unlock()
+		if resp.Error != "" {
+			b.failures[rank] = resp.Error
+			return
+		}
+		var bp utils.Breakpoint
+		json.Unmarshal(resp.Result, &bp)
+		b.installs[rank] = bp
+	})
+
+	breakpoints.mux.Lock()
+	s := fmt.Sprintf("Breakpoint %d at %s:", b.id, location) + breakpointInstalls(b)
+	breakpoints.mux.Unlock()
+	if len(missing) != 0 {
+		s += fmt.Sprintf("\nNo answer yet from %s, it is set there once they stop", formatRanks(missing))
+	}
+	t.ShowMessagesAll(s)
+	refreshSource(t)
+}
+
+// changeBreakpoint deletes, disables or enables a breakpoint on ranks, or
+// on all the ranks it is set on if ranks is nil.
+func changeBreakpoint(change string, id int, ranks []int, t *tui.TUI) {
+	breakpoints.mux.Lock()
+	b, ok := breakpoints.byID[id]
+	args := make(map[int][]string)
+	if ok {
+		for rank, bp := range b.installs {
+			if ranks == nil || containsRank(ranks, rank) {
+				args[rank] = []string{strconv.Itoa(bp.Number)}
+			}
+		}
+	}
+	breakpoints.mux.Unlock()
+	if !ok {
+		t.ShowMessagesAll(fmt.Sprintf("No breakpoint %d", id))
+		return
+	}
+
+	var changed []int
+	var failures []string
+	missing := request("break-"+change, args, func(rank int, resp utils.Response) {
+		breakpoints.mux.Lock()
+		defer breakpoints.mux.Unlock()
+		if resp.Error != "" {
+			failures = append(failures, fmt.Sprintf("rank %d: %s", rank, resp.Error))
+			return
+		}
+		switch change {
+		case "delete":
+			delete(b.installs, rank)
+			delete(b.disabled, rank)
+			delete(b.hits, rank)
+		case "disable":
+			b.disabled[rank] = true
+		case "enable":
+			delete(b.disabled, rank)
+		}
+		changed = append(changed, rank)
+	})
+
+	breakpoints.mux.Lock()
+	if change == "delete" && len(b.installs) == 0 {
+		delete(breakpoints.byID, id)
+	}
+	s := fmt.Sprintf("Breakpoint %d %sd on %s", id, strings.TrimSuffix(change, "e"), formatRanks(changed))
+	for _, failure := range failures {
+		s += "\n" + failure
+	}
+	breakpoints.mux.Unlock()
+	if len(missing) != 0 {
+		s += fmt.Sprintf("\nNo answer yet from %s", formatRanks(missing))
+	}
+	t.ShowMessagesAll(s)
+	refreshSource(t)
+}
+
+func containsRank(ranks []int, rank int) bool {
+	for _, r := range ranks {
+		if r == rank {
+			return true
+		}
+	}
+	return false
+}
+
+// breakpointInstalls tells where a breakpoint is set, is pending or could
+// not be set. breakpoints.mux must be held by the caller.
+func breakpointInstalls(b *globalBreakpoint) string {
+	var set, pending, disabled []int
+	for rank, bp := range b.installs {
+		switch {
+		case b.disabled[rank]:
+			disabled = append(disabled, rank)
+		case bp.Pending:
+			pending = append(pending, rank)
+		default:
+			set = append(set, rank)
+		}
+	}
+
+	s := ""
+	if len(set) != 0 {
+		s += fmt.Sprintf(" set on %s", formatRanks(set))
+	}
+	if len(pending) != 0 {
+		s += fmt.Sprintf(" pending on %s", formatRanks(pending))
+	}
+	if len(disabled) != 0 {
+		s += fmt.Sprintf(" disabled on %s", formatRanks(disabled))
+	}
+	failures := make(map[string][]int)
+	for rank, failure := range b.failures {
+		failures[failure] = append(failures[failure], rank)
+	}
+	for failure, ranks := range failures {
+		s += fmt.Sprintf(" failed on %s (%s)", formatRanks(ranks), failure)
+	}
+	if s == "" {
+		s = " not set on any rank"
+	}
+	return s
+}
+
+// breakpointHit counts a stop of a rank at the breakpoint gdb numbers
+// bkptNo there.
+func breakpointHit(rank int, bkptNo int) {
+	breakpoints.mux.Lock()
+	defer breakpoints.mux.Unlock()
+	if b := breakpointOf(rank, bkptNo); b != nil {
+		b.hits[rank]++
+	}
+}
+
+// breakpointModified takes a breakpoint of a rank as gdb changed it on its
+// own, e.g. a pending one it set once the library it is in was loaded.
+func breakpointModified(rank int, bp utils.Breakpoint, t *tui.TUI) {
+	breakpoints.mux.Lock()
+	b := breakpointOf(rank, bp.Number)
+	var was utils.Breakpoint
+	if b != nil {
+		was = b.installs[rank]
+		b.installs[rank] = bp
+	}
+	breakpoints.mux.Unlock()
+	if b == nil || (was.Pending == bp.Pending && was.File == bp.File && was.Line == bp.Line) {
+		return
+	}
+	if was.Pending && !bp.Pending {
+		where := bp.Function
+		if bp.File != "" {
+			where = fmt.Sprintf("%s:%s", bp.File, bp.Line)
+		}
+		logEvent(rank, fmt.Sprintf("set pending breakpoint %d at %s", b.id, where), t)
+	}
+	refreshSource(t)
+}
+
+// globalBreakpointID gives the ID of the breakpoint gdb numbers bkptNo on
+// a rank, if it was set with pdb_break.
+func globalBreakpointID(rank int, bkptNo int) (int, bool) {
+	breakpoints.mux.Lock()
+	defer breakpoints.mux.Unlock()
+	if b := breakpointOf(rank, bkptNo); b != nil {
+		return b.id, true
+	}
+	return 0, false
+}
+
+// breakpointOf finds the breakpoint gdb numbers bkptNo on a rank.
+// breakpoints.mux must be held by the caller.
+func breakpointOf(rank int, bkptNo int) *globalBreakpoint {
+	for _, b := range breakpoints.byID {
+		if bp, ok := b.installs[rank]; ok && bp.Number == bkptNo {
+			return b
+		}
+	}
+	return nil
+}
+
+// prettyPrintBreakpoints shows every breakpoint, or where a breakpoint is
+// on every rank when id is not 0.
+func prettyPrintBreakpoints(id int, t *tui.TUI) {
+	t.ShowMessagesAll(breakpointsText(id))
+}
+
+func breakpointsText(id int) string {
+	breakpoints.mux.Lock()
+	defer breakpoints.mux.Unlock()
+
+	if id == 0 {
+		var ids []int
+		for id := range breakpoints.byID {
+			ids = append(ids, id)
+		}
+		sort.Ints(ids)
+		s := "Breakpoints:\n"
+		if len(ids) == 0 {
+			s += "None\n"
+		}
+		for _, id := range ids {
+			b := breakpoints.byID[id]
+			s += fmt.Sprintf("%d %s:%s", b.id, b.location, breakpointInstalls(b))
+			if hits := breakpointHits(b); hits != "" {
+				s += ", hits " + hits
+			}
+			s += "\n"
+		}
+		return s
+	}
+
+	b, ok := breakpoints.byID[id]
+	if !ok {
+		return fmt.Sprintf("No breakpoint %d", id)
+	}
+	s := fmt.Sprintf("Breakpoint %d at %s:\n", b.id, b.location)
+	var ranks []int
+	for rank := range b.installs {
+		ranks = append(ranks, rank)
+	}
+	for rank := range b.failures {
+		ranks = append(ranks, rank)
+	}
+	sort.Ints(ranks)
+	for _, rank := range ranks {
+		bp, ok := b.installs[rank]
+		if !ok {
+			s += fmt.Sprintf("Rank %d: %s\n", rank, b.failures[rank])
+			continue
+		}
+		s += fmt.Sprintf("Rank %d: gdb breakpoint %d", rank, bp.Number)
+		if bp.File != "" {
+			s += fmt.Sprintf(" at %s:%s", bp.File, bp.Line)
+		}
+		if bp.Pending {
+			s += ", pending"
+		}
+		if b.disabled[rank] {
+			s += ", disabled"
+		}
+		s += fmt.Sprintf(", %d hits\n", b.hits[rank])
+	}
+	return s
+}
+
+// breakpointHits tells how many times every rank hit a breakpoint, grouping
+// the ranks with the same count, e.g. [0-3]: 2 [4]: 1.
+// breakpoints.mux must be held by the caller.
+func breakpointHits(b *globalBreakpoint) string {
+	counts := make(map[int][]int)
+	for rank, hits := range b.hits {
+		counts[hits] = append(counts[hits], rank)
+	}
+	var sorted []int
+	for hits := range counts {
+		sorted = append(sorted, hits)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(sorted)))
+
+	var parts []string
+	for _, hits := range sorted {
+		parts = append(parts, fmt.Sprintf("%s: %d", formatRanks(counts[hits]), hits))
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"git.cse.iitk.ac.in/ssaha/parallel-debugger/pd-server/tui"
+	"git.cse.iitk.ac.in/ssaha/parallel-debugger/utils"
+)
+
+// takeCollectiveInput runs pdb_run_to_collective, and tells if input was it:
+//
+//	pdb_run_to_collective [name] [r=...]
+//
+// Every rank continues until it calls a tracked collective, or the named
+// one, and stays stopped there.
+func takeCollectiveInput(input string, t *tui.TUI) bool {
+	if !strings.HasPrefix(input, "pdb_") {
+		return false
+	}
+	command, ranks := parseInput(input)
+	fields := strings.Fields(command)
+	if len(fields) == 0 || fields[0] != "run_to_collective" {
+		return false
+	}
+	if len(fields) > 2 {
+		go t.ShowMessagesAll("Usage: pdb_run_to_collective [name] [r=...]")
+		return true
+	}
+	name := ""
+	if len(fields) == 2 {
+		name = fields[1]
+	}
+	runToCollective(name, ranks, t)
+	return true
+}
+
+// runToCollective resumes ranks until they reach their next collective,
+// and shows which collective every one of them reached.
+func runToCollective(name string, ranks []int, t *tui.TUI) {
+	if ranks == nil {
+		ranks = allRanks()
+	}
+
+	var targets, skipped []int
+	rankStates.mux.Lock()
+	for _, rank := range ranks {
+		if _, ok := connections[rank]; !ok {
+			continue
+		}
+		if info, ok := rankStates.states[rank]; ok && rankDone(info) {
+			skipped = append(skipped, rank)
+		} else {
+			targets = append(targets, rank)
+		}
+	}
+	rankStates.mux.Unlock()
+	if len(targets) == 0 {
+		go t.ShowMessagesAll("None of the ranks can be resumed")
+		return
+	}
+
+	// The barrier only blocks input here, the ranks are done once they
+	// answer the request.
+	startBarrier(targets)
+	go func() {
+		shown := "run_to_collective"
+		if name != "" {
+			shown += " " + name
+		}
+		t.Update(func() { t.ShowUserInputClients(shown, targets) })
+
+		var arg []string
+		if name != "" {
+			arg = []string{name}
+		}
+		args := make(map[int][]string)
+		for _, rank := range targets {
+			args[rank] = arg
+		}
+
+		reached := make(map[int]utils.CollectiveInfo)
+		failed := make(map[int]string)
+		finished := false
+		var mux sync.Mutex
+		missing := requestWithin(*stepTimeout, "run-to-collective", args, func(rank int, resp utils.Response) {
+			var c utils.CollectiveInfo
+			if resp.Error == "" && json.Unmarshal(resp.Result, &c) != nil {
+				resp.Error = "bad response"
+			}
+			mux.Lock()
+			defer mux.Unlock()
+			if finished {
+				return
+			}
+			if resp.Error != "" {
+				failed[rank] = resp.Error
+			} else {
+				reached[rank] = c
+			}
+		})
+		endBarrier()
+
+		mux.Lock()
+		finished = true
+		s := collectiveText(reached, failed, missing)
+		mux.Unlock()
+		if len(skipped) != 0 {
+			s += fmt.Sprintf("Not resumed, since they are done: %s\n", formatRanks(skipped))
+		}
+		if len(missing) != 0 {
+			s += fmt.Sprintf("Still not at a collective after %s, use pdb_interrupt to stop them\n", *stepTimeout)
+		}
+		t.ShowMessagesAll(s)
+	}()
+}
+
+// collectiveText groups ranks by the collective they reached, or by where
+// they stopped instead.
+func collectiveText(reached map[int]utils.CollectiveInfo, failed map[int]string, running []int) string {
+	groups := make(map[string][]int)
+	if len(running) != 0 {
+		groups["still running"] = running
+	}
+	functions := make(map[string]bool)
+	for rank, c := range reached {
+		key := fmt.Sprintf("%s at %s", c.FunctionName, c.LineInfo)
+		groups[key] = append(groups[key], rank)
+		functions[c.FunctionName] = true
+	}
+
+	rankStates.mux.Lock()
+	for rank, err := range failed {
+		key := "failed: " + err
+		if info, ok := rankStates.states[rank]; ok && info.State != "running" {
+			key = "stopped elsewhere: " + stateSummary(*info)
+		}
+		groups[key] = append(groups[key], rank)
+	}
+	rankStates.mux.Unlock()
+
+	var keys []string
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	s := "Ranks now at:\n"
+	for _, key := range keys {
+		s += fmt.Sprintf("%s: %s\n", formatRanks(groups[key]), key)
+	}
+	if len(functions) == 1 && len(failed) == 0 && len(running) == 0 {
+		for function := range functions {
+			s += fmt.Sprintf("All of them are at %s\n", function)
+		}
+	} else if len(functions) > 1 {
+		s += "The ranks are at different collectives\n"
+	}
+	return s
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"git.cse.iitk.ac.in/ssaha/parallel-debugger/pd-server/tui"
+	"git.cse.iitk.ac.in/ssaha/parallel-debugger/utils"
+)
+
+// maxCandidates is how many candidates are shown at most.
+const maxCandidates = 200
+
+// pdbCommands are the commands handled by the server.
+var pdbCommands = []string{
+	"pdb_break", "pdb_continue", "pdb_delete", "pdb_detach", "pdb_diff",
+	"pdb_disable", "pdb_enable", "pdb_events", "pdb_export", "pdb_filter",
+	"pdb_info_break", "pdb_interrupt", "pdb_layout", "pdb_listcoll",
+	"pdb_next", "pdb_run_to_collective", "pdb_setvar", "pdb_show", "pdb_source",
+	"pdb_status", "pdb_step", "pdb_summary", "pdb_trackcoll", "pdb_vars",
+}
+
+// localCommands are the commands which change the panes.
+var localCommands = []string{"add", "quit", "remove", "swap"}
+
+// gdbCommands are the gdb commands which are commonly sent to the ranks.
+var gdbCommands = []string{
+	"advance", "backtrace", "break", "call", "continue", "delete", "disable",
+	"display", "down", "enable", "finish", "frame", "info", "list", "next",
+	"nexti", "print", "ptype", "return", "set", "step", "stepi", "tbreak",
+	"undisplay", "until", "up", "watch", "whatis", "x",
+}
+
+var gdbInfoCommands = []string{
+	"args", "breakpoints", "display", "frame", "functions", "line", "locals",
+	"registers", "signals", "source", "symbol", "threads", "types", "variables",
+	"watchpoints",
+}
+
+// trackableCollectives are the collectives which mpic.so lets pd track, as
+// long as the symbols of the program are not known.
+var trackableCollectives = []string{"MPI_Barrier", "MPI_Bcast"}
+
+// completions holds the symbols and source files of the program, which are
+// fetched from a client the first time they are needed.
+var completions struct {
+	symbols  []string
+	files    []string
+	fetched  bool
+	fetching bool
+	from     int
+	mux      sync.Mutex
+}
+
+// resetCompletions forgets the symbols of the program of a finished
+// session.
+func resetCompletions() {
+	completions.mux.Lock()
+	completions.symbols = nil
+	completions.files = nil
+	completions.fetched = false
+	completions.fetching = false
+	completions.mux.Unlock()
+}
+
+// completeInput completes the last word of input. Along with the completed
+// input, it gives back the candidates to show when there is more than one,
+// or a note when there are none yet.
+func completeInput(input string) (string, []string) {
+	word := input[strings.LastIndexAny(input, " \t")+1:]
+	head := input[:len(input)-len(word)]
+	candidates, note := candidatesFor(strings.Fields(head), word)
+
+	var matching []string
+	seen := make(map[string]bool)
+	for _, c := range candidates {
+		if strings.HasPrefix(c, word) && !seen[c] {
+			seen[c] = true
+			matching = append(matching, c)
+		}
+	}
+	sort.Strings(matching)
+
+	switch len(matching) {
+	case 0:
+		if note != "" {
+			return input, []string{note}
+		}
+		return input, nil
+	case 1:
+		completed := head + matching[0]
+		if !strings.HasSuffix(completed, "=") && !strings.HasSuffix(completed, ":") && !strings.HasSuffix(completed, "/") {
+			completed += " "
+		}
+		return completed, nil
+	}
+
+	prefix := matching[0]
+	for _, c := range matching[1:] {
+		for !strings.HasPrefix(c, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	if len(matching) > maxCandidates {
+		matching = append(matching[:maxCandidates], fmt.Sprintf("(%d more)", len(matching)-maxCandidates))
+	}
+	if note != "" {
+		matching = append(matching, note)
+	}
+	return head + prefix, matching
+}
+
+// candidatesFor gives the candidates for a word which follows the words in
+// prev, along with a note if some may be missing.
+func candidatesFor(prev []string, word string) (candidates []string, note string) {
+	if len(prev) == 0 {
+		candidates = append(candidates, pdbCommands...)
+		candidates = append(candidates, localCommands...)
+		candidates = append(candidates, gdbCommands...)
+		if strings.HasPrefix(word, "pdb_") {
+			for _, command := range gdbCommands {
+				candidates = append(candidates, "pdb_"+command)
+			}
+		}
+		return
+	}
+
+	isPdb := strings.HasPrefix(prev[0], "pdb_")
+	if isPdb && (strings.HasPrefix(word, "r=") || strings.HasPrefix(word, "[r=")) {
+		return rankSpecCandidates(strings.HasPrefix(word, "[")), ""
+	}
+	if strings.HasPrefix(word, "$") {
+		for _, name := range append([]string{"rank", "size"}, rankVarNames()...) {
+			candidates = append(candidates, "$"+name)
+		}
+		return candidates, ""
+	}
+
+	switch command := strings.TrimPrefix(prev[0], "pdb_"); command {
+	case "trackcoll", "run_to_collective":
+		symbols, note := programSymbols()
+		for _, symbol := range symbols {
+			if strings.HasPrefix(symbol, "internal_MPI_") {
+				candidates = append(candidates, strings.TrimPrefix(symbol, "internal_"))
+			}
+		}
+		if len(candidates) == 0 {
+			candidates = trackableCollectives
+		}
+		return candidates, note
+	case "break", "tbreak", "until", "advance", "list":
+		symbols, note := programSymbols()
+		candidates = append(candidates, symbols...)
+		completions.mux.Lock()
+		for _, file := range completions.files {
+			candidates = append(candidates, file+":")
+		}
+		completions.mux.Unlock()
+		return candidates, note
+	case "print", "display", "ptype", "whatis", "call", "watch", "x", "setvar":
+		if command == "setvar" && len(prev) < 2 {
+			return rankVarNames(), ""
+		}
+		return programSymbols()
+	case "info":
+		if len(prev) == 1 {
+			return gdbInfoCommands, ""
+		}
+	case "layout":
+		if len(prev) == 1 {
+			return tui.Layouts, ""
+		}
+	case "diff":
+		if !isPdb {
+			break
+		}
+		if len(prev) < 3 {
+			for _, rank := range allRanks() {
+				candidates = append(candidates, strconv.Itoa(rank))
+			}
+			return candidates, ""
+		}
+		symbols, note := programSymbols()
+		return append([]string{"output", "backtrace", "locals"}, symbols...), note
+	case "filter":
+		if len(prev) == 1 {
+			return []string{"-v", "off"}, ""
+		}
+	case "step", "next":
+		if isPdb && len(prev) == 1 {
+			return []string{"-p"}, ""
+		}
+	case "source":
+		if isPdb {
+			candidates = []string{"off"}
+			for _, rank := range allRanks() {
+				candidates = append(candidates, strconv.Itoa(rank))
+			}
+			return candidates, ""
+		}
+	case "delete", "disable", "enable", "info_break":
+		if isPdb {
+			breakpoints.mux.Lock()
+			for id := range breakpoints.byID {
+				candidates = append(candidates, strconv.Itoa(id))
+			}
+			breakpoints.mux.Unlock()
+			return candidates, ""
+		}
+	}
+	return nil, ""
+}
+
+// rankSpecCandidates gives rank specs for all the ranks, and for the ranks
+// in every state the overview shows.
+func rankSpecCandidates(bracket bool) []string {
+	groups := map[string][]int{"": allRanks()}
+	rankStates.mux.Lock()
+	var infos []utils.StateInfo
+	for _, rank := range allRanks() {
+		if info, ok := rankStates.states[rank]; ok {
+			infos = append(infos, *info)
+		}
+	}
+	rankStates.mux.Unlock()
+	for _, info := range infos {
+		state := overviewState(info)
+		groups[state] = append(groups[state], info.Rank)
+	}
+
+	var candidates []string
+	for _, ranks := range groups {
+		spec := "r=" + rankSpec(ranks)
+		if bracket {
+			spec = "[" + spec + "]"
+		}
+		candidates = append(candidates, spec)
+	}
+	return candidates
+}
+
+// rankSpec formats ranks as they are given to pdb_ commands, e.g. 0..4,5
+// for [0-3,5].
+func rankSpec(ranks []int) string {
+	sorted := append([]int(nil), ranks...)
+	sort.Ints(sorted)
+
+	var parts []string
+	for i := 0; i < len(sorted); {
+		j := i
+		for j+1 < len(sorted) && sorted[j+1] == sorted[j]+1 {
+			j++
+		}
+		if i == j {
+			parts = append(parts, strconv.Itoa(sorted[i]))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d..%d", sorted[i], sorted[j]+1))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
+
+// rankVarNames gives the names of the variables set with pdb_setvar.
+func rankVarNames() (names []string) {
+	rankVars.mux.Lock()
+	defer rankVars.mux.Unlock()
+	for _, vars := range rankVars.vars {
+		for name := range vars {
+			names = append(names, name)
+		}
+	}
+	return
+}
+
+// programSymbols gives the symbols of the program if they were fetched
+// already. Otherwise it starts fetching them, and gives back a note about
+// it.
+func programSymbols() ([]string, string) {
+	completions.mux.Lock()
+	defer completions.mux.Unlock()
+	if completions.fetched {
+		return completions.symbols, ""
+	}
+	if completions.fetching {
+		return nil, fmt.Sprintf("(fetching the symbols from rank %d)", completions.from)
+	}
+
+	rank, ok := stoppedRank()
+	if !ok {
+		return nil, "(the symbols are fetched once a rank is stopped)"
+	}
+	completions.fetching = true
+	completions.from = rank
+	go fetchSymbols(rank)
+	return nil, fmt.Sprintf("(fetching the symbols from rank %d, press Tab again)", rank)
+}
+
+// stoppedRank gives the lowest rank which is stopped, which is the one
+// which answers requests right away.
+func stoppedRank() (int, bool) {
+	rankStates.mux.Lock()
+	defer rankStates.mux.Unlock()
+	for _, rank := range allRanks() {
+		if info, ok := rankStates.states[rank]; ok && info.State == "stopped" && connections[rank] != nil {
+			return rank, true
+		}
+	}
+	return 0, false
+}
+
+// fetchSymbols asks a rank for the symbols and the source files of the
+// program, which are the same for every rank.
+func fetchSymbols(rank int) {
+	symbolsMissing := requestAll("symbols", []int{rank}, nil, func(rank int, resp utils.Response) {
+		var symbols []string
+		if resp.Error == "" && json.Unmarshal(resp.Result, &symbols) == nil {
+			completions.mux.Lock()
+			completions.symbols = symbols
+			completions.mux.Unlock()
+		}
+	})
+	filesMissing := requestAll("source-files", []int{rank}, nil, func(rank int, resp utils.Response) {
+		var files []string
+		if resp.Error == "" && json.Unmarshal(resp.Result, &files) == nil {
+			completions.mux.Lock()
+			completions.files = files
+			completions.mux.Unlock()
+		}
+	})
+
+	// A rank which did not answer in time may be running, so another one is
+	// asked next time.
+	completions.mux.Lock()
+	completions.fetching = false
+	completions.fetched = len(symbolsMissing) == 0 && len(filesMissing) == 0
+	completions.mux.Unlock()
+}
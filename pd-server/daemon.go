@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"git.cse.iitk.ac.in/ssaha/parallel-debugger/utils"
+	"github.com/gdamore/tcell/terminfo"
+	"github.com/kr/pty"
+)
+
+var daemon = flag.Bool("daemon", false, "Run the session of run or attach-job in the background, so that closing the terminal only detaches the UI, which pd-server attach brings back")
+
+// daemonEnv is set for the server which runs the session in the background.
+// Its terminal is a pseudo-terminal, whose master end it gets as file 3.
+const daemonEnv = "PD_DAEMON"
+
+// Every frame sent by pd-server attach is a kind, the length of its payload
+// and the payload, which is what was typed or the size of the terminal.
+const (
+	frameInput = 'i'
+	frameSize  = 's'
+)
+
+// maxPending is how much of what the server prints is kept for the next
+// terminal to attach, while none is attached.
+const maxPending = 64 << 10
+
+// clientTimeout is how long an attached terminal may take to take the
+// output, before it is detached.
+const clientTimeout = 10 * time.Second
+
+// control holds the pseudo-terminal of a daemon and the terminal attached
+// to it, if any, which gets everything the server prints.
+var control struct {
+	master  *os.File
+	ln      net.Listener
+	client  net.Conn
+	pending []byte
+	// screen is true while the UI is up, so that a terminal which attaches
+	// has to be switched to the screen the UI draws on.
+	screen bool
+	mux    sync.Mutex
+}
+
+// socketDir gives the directory with the control sockets of the daemons of
+// the user, which nobody else may use.
+func socketDir() (string, error) {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir != "" {
+		dir = filepath.Join(dir, "pd")
+	} else {
+		dir = filepath.Join(os.TempDir(), fmt.Sprintf("pd-%d", os.Getuid()))
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return "", err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !info.IsDir() || info.Mode().Perm() != 0700 || (ok && int(stat.Uid) != os.Getuid()) {
+		return "", fmt.Errorf("%s must be a directory only you can use", dir)
+	}
+	return dir, nil
+}
+
+func socketPath(pid int) (string, error) {
+	dir, err := socketDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, fmt.Sprintf("%d.sock", pid)), nil
+}
+
+// startDaemon starts the server again in the background, in a session of
+// its own with a pseudo-terminal for the UI, and attaches this terminal to
+// it.
+func startDaemon() {
+	if cmd := flag.Arg(0); cmd != "run" && cmd != "attach-job" {
+		log.Fatalln("-daemon works with run and attach-job")
+	}
+	ptm, pts, err := pty.Open()
+	utils.CheckError(err)
+	if size, err := pty.GetsizeFull(os.Stdin); err == nil {
+		pty.Setsize(ptm, size)
+	}
+	exe, err := os.Executable()
+	utils.CheckError(err)
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), daemonEnv+"=1")
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = pts, pts, pts
+	cmd.ExtraFiles = []*os.File{ptm}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true, Ctty: 0}
+	utils.CheckError(cmd.Start())
+	pts.Close()
+	ptm.Close()
+
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	path, err := socketPath(cmd.Process.Pid)
+	utils.CheckError(err)
+	for {
+		if _, err := os.Stat(path); err == nil {
+			break
+		}
+		select {
+		case err := <-exited:
+			log.Fatalf("The session in the background exited: %v\n", err)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	log.Printf("Running the session in the background, pd-server attach %d attaches to it again\n", cmd.Process.Pid)
+	os.Exit(attachSession(path, cmd.Process.Pid))
+}
+
+// serveDaemon takes the terminals which attach to the daemon through its
+// control socket, until the session ends.
+func serveDaemon() {
+	os.Unsetenv(daemonEnv)
+	control.master = os.NewFile(3, "pty")
+	path, err := socketPath(os.Getpid())
+	utils.CheckError(err)
+	control.ln, err = net.Listen("unix", path)
+	utils.CheckError(err)
+	atSessionEnd(stopDaemon)
+
+	go relayOutput()
+	go func() {
+		for {
+			conn, err := control.ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveClient(conn)
+		}
+	}()
+}
+
+// stopDaemon removes the control socket, which tells the attached terminal
+// that the session is over, and lets it go.
+func stopDaemon() {
+	control.ln.Close()
+	control.mux.Lock()
+	if control.client != nil {
+		control.client.Close()
+		control.client = nil
+	}
+	control.mux.Unlock()
+}
+
+// setScreen tells whether the UI is up.
+func setScreen(up bool) {
+	control.mux.Lock()
+	control.screen = up
+	control.mux.Unlock()
+}
+
+// relayOutput passes what the server prints on to the attached terminal, or
+// keeps the last of it while none is attached.
+func relayOutput() {
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := control.master.Read(buf)
+		if err != nil {
+			return
+		}
+		control.mux.Lock()
+		if control.client != nil {
+			control.client.SetWriteDeadline(time.Now().Add(clientTimeout))
+			if _, err := control.client.Write(buf[:n]); err != nil {
+				control.client.Close()
+				control.client = nil
+			}
+		} else {
+			control.pending = append(control.pending, buf[:n]...)
+			if len(control.pending) > maxPending {
+				control.pending = control.pending[len(control.pending)-maxPending:]
+			}
+		}
+		control.mux.Unlock()
+	}
+}
+
+// serveClient attaches a terminal, which takes over from the one attached
+// before, and passes what is typed in it to the server.
+func serveClient(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	kind, payload, err := readFrame(r)
+	if err != nil || kind != frameSize {
+		return
+	}
+	size, err := parseSize(payload)
+	if err != nil {
+		return
+	}
+
+	control.mux.Lock()
+	if control.client != nil {
+		control.client.Close()
+		control.client = nil
+	}
+	screen := control.screen
+	control.mux.Unlock()
+
+	if screen {
+		// The UI only draws what changed, unless the terminal is resized.
+		// It is made a column narrower first, so that it draws all of the
+		// screen once it gets the size of the new terminal, and what it
+		// draws in between is not passed on.
+		narrower := *size
+		if narrower.Cols > 1 {
+			narrower.Cols--
+		}
+		pty.Setsize(control.master, &narrower)
+		time.Sleep(100 * time.Millisecond)
+	}
+	control.mux.Lock()
+	if screen {
+		if ti, err := terminfo.LookupTerminfo(os.Getenv("TERM")); err == nil {
+			io.WriteString(conn, ti.EnterCA+ti.EnterKeypad+ti.Clear)
+		}
+	} else {
+		conn.Write(control.pending)
+	}
+	control.pending = nil
+	control.client = conn
+	control.mux.Unlock()
+	pty.Setsize(control.master, size)
+
+	for {
+		kind, payload, err := readFrame(r)
+		if err != nil {
+			break
+		}
+		switch kind {
+		case frameInput:
+			control.master.Write(payload)
+		case frameSize:
+			if size, err := parseSize(payload); err == nil {
+				pty.Setsize(control.master, size)
+			}
+		}
+	}
+	control.mux.Lock()
+	if control.client == conn {
+		control.client = nil
+	}
+	control.mux.Unlock()
+}
+
+// detachClient lets the attached terminal go, and tells if there was one.
+func detachClient() bool {
+	control.mux.Lock()
+	defer control.mux.Unlock()
+	if control.client == nil {
+		return false
+	}
+	control.client.Close()
+	control.client = nil
+	return true
+}
+
+func readFrame(r io.Reader) (kind byte, payload []byte, err error) {
+	var header [3]byte
+	if _, err = io.ReadFull(r, header[:]); err != nil {
+		return
+	}
+	payload = make([]byte, binary.BigEndian.Uint16(header[1:]))
+	_, err = io.ReadFull(r, payload)
+	return header[0], payload, err
+}
+
+func writeFrame(w io.Writer, kind byte, payload []byte) error {
+	for {
+		n := len(payload)
+		if n > 0xffff {
+			n = 0xffff
+		}
+		header := []byte{kind, 0, 0}
+		binary.BigEndian.PutUint16(header[1:], uint16(n))
+		if _, err := w.Write(append(header, payload[:n]...)); err != nil {
+			return err
+		}
+		payload = payload[n:]
+		if len(payload) == 0 {
+			return nil
+		}
+	}
+}
+
+// parseSize parses the size of a terminal, sent as <rows> <cols>.
+func parseSize(payload []byte) (*pty.Winsize, error) {
+	var rows, cols int
+	if _, err := fmt.Sscanf(string(payload), "%d %d", &rows, &cols); err != nil {
+		return nil, err
+	}
+	if rows <= 0 || cols <= 0 || rows > 0xffff || cols > 0xffff {
+		return nil, fmt.Errorf("bad terminal size %s", payload)
+	}
+	return &pty.Winsize{Rows: uint16(rows), Cols: uint16(cols)}, nil
+}
+
+func formatSize(rows int, cols int) []byte {
+	return []byte(strconv.Itoa(rows) + " " + strconv.Itoa(cols))
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"git.cse.iitk.ac.in/ssaha/parallel-debugger/pd-server/tui"
+	"git.cse.iitk.ac.in/ssaha/parallel-debugger/utils"
+)
+
+// maxDiffLines is how many lines of every side are compared at most.
+const maxDiffLines = 2000
+
+const diffUsage = "Usage: pdb_diff <rank> <rank> [output|backtrace|locals|<expression>], e.g. pdb_diff 6 7 backtrace. What the ranks printed since the last command is compared by default."
+
+// diffRanks handles pdb_diff, which shows a side-by-side diff of two ranks:
+//
+//	pdb_diff <a> <b> [output]     what they printed since the last command
+//	pdb_diff <a> <b> backtrace    their stacks, also bt
+//	pdb_diff <a> <b> locals       the variables of their current frames
+//	pdb_diff <a> <b> <expression> the value of an expression, where $rank
+//	                              and the like are expanded for every rank
+func diffRanks(args []string, t *tui.TUI) {
+	if len(args) < 2 {
+		t.ShowMessagesAll(diffUsage)
+		return
+	}
+	var ranks [2]int
+	for i, arg := range args[:2] {
+		rank, err := strconv.Atoi(arg)
+		if _, ok := connections[rank]; err != nil || !ok {
+			t.ShowMessagesAll(fmt.Sprintf("There is no rank %s. %s", arg, diffUsage))
+			return
+		}
+		ranks[i] = rank
+	}
+	what := strings.Join(args[2:], " ")
+
+	var sides [2][]string
+	var subject string
+	var err error
+	switch what {
+	case "", "output":
+		var input string
+		sides, input = lastOutput(ranks)
+		subject = "the output"
+		if input != "" {
+			subject += fmt.Sprintf(" since `%s'", input)
+		}
+	case "backtrace", "bt":
+		sides, err = requestDiff("backtrace", ranks, nil, backtraceLines)
+		subject = "the backtraces"
+	case "locals":
+		sides, err = requestDiff("locals", ranks, nil, localsLines)
+		subject = "the locals"
+	default:
+		var exprs [2]string
+		for i, rank := range ranks {
+			if exprs[i], err = expandTemplate(what, rank); err != nil {
+				t.ShowMessagesAll(err.Error())
+				return
+			}
+		}
+		sides, err = requestDiff("evaluate", ranks, &exprs, valueLines)
+		subject = fmt.Sprintf("the value of %s", what)
+	}
+	if err != nil {
+		t.ShowMessagesAll(fmt.Sprintf("Could not compare %s of %d and %d: %s", subject, ranks[0], ranks[1], err))
+		return
+	}
+
+	for i := range sides {
+		if len(sides[i]) > maxDiffLines {
+			sides[i] = append(sides[i][:maxDiffLines], fmt.Sprintf("(%d more lines not compared)", len(sides[i])-maxDiffLines))
+		}
+	}
+	rows := diffLines(sides[0], sides[1])
+	differ := 0
+	for _, row := range rows {
+		if row.Change != tui.DiffSame {
+			differ++
+		}
+	}
+	title := fmt.Sprintf("%s of rank %d and rank %d", subject, ranks[0], ranks[1])
+	switch {
+	case len(rows) == 0:
+		title += ": nothing on either side"
+	case differ == 0:
+		title += ": the same"
+	case differ == 1:
+		title += ": 1 line differs"
+	default:
+		title += fmt.Sprintf(": %d lines differ", differ)
+	}
+	t.ShowDiff(strings.ToUpper(title[:1])+title[1:],
+		fmt.Sprintf("rank-%d", ranks[0]), fmt.Sprintf("rank-%d", ranks[1]), rows)
+}
+
+// lastOutput gives what the ranks printed since the last command, along
+// with that command.
+func lastOutput(ranks [2]int) (sides [2][]string, input string) {
+	transcript.mux.Lock()
+	defer transcript.mux.Unlock()
+	if len(transcript.blocks) == 0 {
+		return
+	}
+	block := transcript.blocks[len(transcript.blocks)-1]
+	for i, rank := range ranks {
+		sides[i] = append([]string(nil), block.output[rank]...)
+	}
+	return sides, block.input
+}
+
+// requestDiff asks both ranks for op, with the argument in args for every
+// rank if any, and turns their results into lines with toLines.
+func requestDiff(op string, ranks [2]int, args *[2]string, toLines func(result json.RawMessage) ([]string, error)) (sides [2][]string, err error) {
+	rankArgs := make(map[int][]string)
+	for i, rank := range ranks {
+		if c := connections[rank]; c == nil {
+			return sides, fmt.Errorf("rank %d is not connected", rank)
+		}
+		rankArgs[rank] = nil
+		if args != nil {
+			rankArgs[rank] = []string{args[i]}
+		}
+	}
+
+	var errs [2]error
+	var results [2]json.RawMessage
+	missing := request(op, rankArgs, func(rank int, resp utils.Response) {
+		for i := range ranks {
+			if ranks[i] != rank {
+				continue
+			}
+			if resp.Error != "" {
+				errs[i] = fmt.Errorf("rank %d: %s", rank, resp.Error)
+			}
+			results[i] = resp.Result
+		}
+	})
+	if len(missing) != 0 {
+		return sides, fmt.Errorf("%s did not answer, as it is still running", formatRanks(missing))
+	}
+	for i := range ranks {
+		if errs[i] != nil {
+			return sides, errs[i]
+		}
+		if sides[i], err = toLines(results[i]); err != nil {
+			return sides, err
+		}
+	}
+	return sides, nil
+}
+
+func backtraceLines(result json.RawMessage) ([]string, error) {
+	var frames []utils.Frame
+	if err := json.Unmarshal(result, &frames); err != nil {
+		return nil, err
+	}
+	var lines []string
+	for _, frame := range frames {
+		lines = append(lines, fmt.Sprintf("#%d %s", frame.Level, frame))
+	}
+	return lines, nil
+}
+
+func localsLines(result json.RawMessage) ([]string, error) {
+	var vars []utils.Variable
+	if err := json.Unmarshal(result, &vars); err != nil {
+		return nil, err
+	}
+	var lines []string
+	for _, v := range vars {
+		value := prettyValue(v.Value)
+		value[0] = v.Name + " = " + value[0]
+		lines = append(lines, value...)
+	}
+	return lines, nil
+}
+
+func valueLines(result json.RawMessage) ([]string, error) {
+	var value string
+	if err := json.Unmarshal(result, &value); err != nil {
+		return nil, err
+	}
+	return prettyValue(value), nil
+}
+
+// prettyValue breaks a value as gdb prints it, e.g. {x = 1, y = {2, 3}},
+// into a line for every member, indented by how deeply it is nested, so
+// that the members which differ stand out in a diff.
+func prettyValue(value string) []string {
+	var lines []string
+	var line strings.Builder
+	depth := 0
+	newLine := func() {
+		if strings.TrimSpace(line.String()) != "" {
+			lines = append(lines, line.String())
+		}
+		line.Reset()
+		line.WriteString(strings.Repeat("  ", depth))
+	}
+
+	var quote byte
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case quote != 0:
+			line.WriteByte(c)
+			if c == '\\' && i+1 < len(value) {
+				i++
+				line.WriteByte(value[i])
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+			line.WriteByte(c)
+		case c == '{':
+			line.WriteByte(c)
+			depth++
+			newLine()
+		case c == '}':
+			if depth > 0 {
+				depth--
+			}
+			newLine()
+			line.WriteByte(c)
+		case c == ',' && depth > 0:
+			line.WriteByte(c)
+			newLine()
+			for i+1 < len(value) && value[i+1] == ' ' {
+				i++
+			}
+		default:
+			line.WriteByte(c)
+		}
+	}
+	newLine()
+	if len(lines) == 0 {
+		lines = []string{""}
+	}
+	return lines
+}
+
+// diffLines compares two lists of lines, keeping the longest run of lines
+// they have in common, and gives the rows of a side-by-side diff of them.
+// Where lines were taken out of a and others put in their place in b, they
+// are paired as changed lines.
+func diffLines(a []string, b []string) []tui.DiffRow {
+	// The lines they start and end with alike need no table.
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	var rows []tui.DiffRow
+	for _, line := range a[:prefix] {
+		rows = append(rows, tui.DiffRow{Left: line, Right: line})
+	}
+
+	// common[i][j] is how many lines a[i:] and b[j:] have in common.
+	midA, midB := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	n, m := len(midA), len(midB)
+	common := make([][]int32, n+1)
+	for i := range common {
+		common[i] = make([]int32, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			switch {
+			case midA[i] == midB[j]:
+				common[i][j] = common[i+1][j+1] + 1
+			case common[i+1][j] >= common[i][j+1]:
+				common[i][j] = common[i+1][j]
+			default:
+				common[i][j] = common[i][j+1]
+			}
+		}
+	}
+
+	var removed, added []string
+	flush := func() {
+		for k := 0; k < len(removed) || k < len(added); k++ {
+			switch {
+			case k < len(removed) && k < len(added):
+				rows = append(rows, tui.DiffRow{Left: removed[k], Right: added[k], Change: tui.DiffChanged})
+			case k < len(removed):
+				rows = append(rows, tui.DiffRow{Left: removed[k], Change: tui.DiffRemoved})
+			default:
+				rows = append(rows, tui.DiffRow{Right: added[k], Change: tui.DiffAdded})
+			}
+		}
+		removed, added = nil, nil
+	}
+	i, j := 0, 0
+	for i < n || j < m {
+		switch {
+		case i < n && j < m && midA[i] == midB[j]:
+			flush()
+			rows = append(rows, tui.DiffRow{Left: midA[i], Right: midB[j]})
+			i++
+			j++
+		case j == m || (i < n && common[i+1][j] >= common[i][j+1]):
+			removed = append(removed, midA[i])
+			i++
+		default:
+			added = append(added, midB[j])
+			j++
+		}
+	}
+	flush()
+
+	for _, line := range a[len(a)-suffix:] {
+		rows = append(rows, tui.DiffRow{Left: line, Right: line})
+	}
+	return rows
+}
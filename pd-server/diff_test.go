@@ -0,0 +1,79 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"git.cse.iitk.ac.in/ssaha/parallel-debugger/pd-server/tui"
+)
+
+func TestDiffLines(t *testing.T) {
+	same := func(line string) tui.DiffRow { return tui.DiffRow{Left: line, Right: line} }
+	changed := func(a, b string) tui.DiffRow { return tui.DiffRow{Left: a, Right: b, Change: tui.DiffChanged} }
+	removed := func(line string) tui.DiffRow { return tui.DiffRow{Left: line, Change: tui.DiffRemoved} }
+	added := func(line string) tui.DiffRow { return tui.DiffRow{Right: line, Change: tui.DiffAdded} }
+
+	tests := []struct {
+		name string
+		a, b []string
+		want []tui.DiffRow
+	}{
+		{"both empty", nil, nil, nil},
+		{"left empty", nil, []string{"a", "b"}, []tui.DiffRow{added("a"), added("b")}},
+		{"right empty", []string{"a", "b"}, nil, []tui.DiffRow{removed("a"), removed("b")}},
+		{"identical", []string{"a", "b", "c"}, []string{"a", "b", "c"},
+			[]tui.DiffRow{same("a"), same("b"), same("c")}},
+		{"prefix only", []string{"a", "b"}, []string{"a", "b", "c", "d"},
+			[]tui.DiffRow{same("a"), same("b"), added("c"), added("d")}},
+		{"suffix only", []string{"x", "b", "c"}, []string{"b", "c"},
+			[]tui.DiffRow{removed("x"), same("b"), same("c")}},
+		{"changed line", []string{"a", "b", "c"}, []string{"a", "x", "c"},
+			[]tui.DiffRow{same("a"), changed("b", "x"), same("c")}},
+		{"more removed than added", []string{"a", "b", "c", "d"}, []string{"a", "x", "d"},
+			[]tui.DiffRow{same("a"), changed("b", "x"), removed("c"), same("d")}},
+		{"more added than removed", []string{"a", "b", "d"}, []string{"a", "x", "y", "d"},
+			[]tui.DiffRow{same("a"), changed("b", "x"), added("y"), same("d")}},
+		{"mixed", []string{"a", "b", "c", "d", "e"}, []string{"a", "x", "c", "e", "f"},
+			[]tui.DiffRow{same("a"), changed("b", "x"), same("c"), removed("d"), same("e"), added("f")}},
+		{"nothing in common", []string{"a", "b"}, []string{"c"},
+			[]tui.DiffRow{changed("a", "c"), removed("b")}},
+	}
+	for _, test := range tests {
+		if got := diffLines(test.a, test.b); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: diffLines(%q, %q) = %+v, want %+v", test.name, test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestPrettyValue(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []string
+	}{
+		{"", []string{""}},
+		{"42", []string{"42"}},
+		{"0x601040 <buf> \"hi, there\"", []string{"0x601040 <buf> \"hi, there\""}},
+		{"{x = 1, y = 2}", []string{"{", "  x = 1,", "  y = 2", "}"}},
+		{"{x = 1, p = {a = 2, b = {3, 4}}}", []string{
+			"{",
+			"  x = 1,",
+			"  p = {",
+			"    a = 2,",
+			"    b = {",
+			"      3,",
+			"      4",
+			"    }",
+			"  }",
+			"}",
+		}},
+		{`{s = "a, {b}", c = 44 ','}`, []string{"{", `  s = "a, {b}",`, `  c = 44 ','`, "}"}},
+		{`{s = "say \"a, b\"", n = 1}`, []string{"{", `  s = "say \"a, b\"",`, "  n = 1", "}"}},
+		{`{c = 92 '\\', d = 39 '\''}`, []string{"{", `  c = 92 '\\',`, `  d = 39 '\''`, "}"}},
+		{"{}", []string{"{", "}"}},
+	}
+	for _, test := range tests {
+		if got := prettyValue(test.value); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("prettyValue(%q) = %q, want %q", test.value, got, test.want)
+		}
+	}
+}
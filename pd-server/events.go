@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"git.cse.iitk.ac.in/ssaha/parallel-debugger/pd-server/tui"
+	"git.cse.iitk.ac.in/ssaha/parallel-debugger/utils"
+)
+
+// eventDelay is how long an event waits for the same event of other ranks,
+// to be logged along with them.
+const eventDelay = 200 * time.Millisecond
+
+// session holds what the status bar tells about the session besides the
+// state of the ranks, and the events which wait to be logged, by their
+// text, in the order they first happened.
+var session struct {
+	name     string
+	lost     map[int]bool
+	events   map[string][]int
+	order    []string
+	flushing bool
+	mux      sync.Mutex
+}
+
+// nameSession sets the name the status bar gives the session.
+func nameSession(name string) {
+	session.mux.Lock()
+	session.name = name
+	session.mux.Unlock()
+}
+
+// resetSession forgets the lost ranks and the events of a finished session.
+func resetSession() {
+	session.mux.Lock()
+	session.lost = make(map[int]bool)
+	session.events = make(map[string][]int)
+	session.order = nil
+	session.mux.Unlock()
+}
+
+// projectName names the session after the program, or else the directory
+// pd-server runs in.
+func projectName() string {
+	if project != "" {
+		return filepath.Base(project)
+	}
+	if dir, err := os.Getwd(); err == nil {
+		return filepath.Base(dir)
+	}
+	return "pd"
+}
+
+// watchStatus keeps the status bar up to date until done is closed.
+func watchStatus(t *tui.TUI, done chan bool) {
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	last := ""
+	for {
+		// The ranks of a replay change on the UI goroutine.
+		var status string
+		t.Update(func() { status = statusLine() })
+		if status != last {
+			t.SetStatus(status)
+			last = status
+		}
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// statusLine tells the name of the session, how many ranks it has and how
+// many were lost, how many collectives are pending and what is in flight,
+// e.g. "a.out │ 8 ranks, 1 lost [3] │ 2 pending collectives │ idle".
+func statusLine() string {
+	session.mux.Lock()
+	name := session.name
+	var lost []int
+	for rank := range session.lost {
+		lost = append(lost, rank)
+	}
+	session.mux.Unlock()
+
+	ranks := fmt.Sprintf("%d ranks", len(allRanks()))
+	if len(lost) != 0 {
+		ranks += fmt.Sprintf(", %d lost %s", len(lost), formatRanks(lost))
+	}
+
+	collectives := "no pending collectives"
+	switch n := len(pendingCollectiveInfo()); n {
+	case 0:
+	case 1:
+		collectives = "1 pending collective"
+	default:
+		collectives = fmt.Sprintf("%d pending collectives", n)
+	}
+
+	var inFlight []string
+	if waiting := barrierPending(); len(waiting) != 0 {
+		inFlight = append(inFlight, fmt.Sprintf("waiting for %s to stop", formatRanks(waiting)))
+	}
+	requests := requestsInFlight()
+	var ops []string
+	for op := range requests {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	for _, op := range ops {
+		inFlight = append(inFlight, fmt.Sprintf("%s from %s", op, formatRanks(requests[op])))
+	}
+	busy := "idle"
+	if len(inFlight) != 0 {
+		busy = "in flight: " + strings.Join(inFlight, ", ")
+	}
+	return strings.Join([]string{name, ranks, collectives, busy}, " │ ")
+}
+
+// logEvent logs an event of a rank, e.g. that it hit a breakpoint, along
+// with the same event of the ranks which follow it within eventDelay.
+func logEvent(rank int, text string, t *tui.TUI) {
+	session.mux.Lock()
+	defer session.mux.Unlock()
+	if _, ok := session.events[text]; !ok {
+		session.order = append(session.order, text)
+	}
+	session.events[text] = append(session.events[text], rank)
+	if !session.flushing {
+		session.flushing = true
+		time.AfterFunc(eventDelay, func() { flushEvents(t) })
+	}
+}
+
+func flushEvents(t *tui.TUI) {
+	session.mux.Lock()
+	events, order := session.events, session.order
+	session.events = make(map[string][]int)
+	session.order = nil
+	session.flushing = false
+	session.mux.Unlock()
+
+	for _, text := range order {
+		ranks := events[text]
+		if len(ranks) == 1 {
+			t.LogEvent(fmt.Sprintf("rank %d %s", ranks[0], text))
+		} else {
+			t.LogEvent(fmt.Sprintf("ranks %s %s", formatRanks(ranks), text))
+		}
+	}
+}
+
+// logStateEvent logs the new state of a rank if it is an event: hitting a
+// breakpoint, getting a signal, calling MPI_Abort or exiting.
+func logStateEvent(info utils.StateInfo, t *tui.TUI) {
+	var text string
+	loc := stateLocation(info)
+	switch {
+	case info.State == "stopped" && info.Reason == "mpi-abort":
+		text = "called MPI_Abort at " + loc
+	case info.State == "stopped" && info.Signal != "":
+		text = fmt.Sprintf("got %s at %s", info.Signal, loc)
+	case info.State == "stopped" && info.BkptNo > 0:
+		if id, ok := globalBreakpointID(info.Rank, info.BkptNo); ok {
+			text = fmt.Sprintf("hit breakpoint %d at %s", id, loc)
+		} else {
+			text = fmt.Sprintf("hit gdb breakpoint %d at %s", info.BkptNo, loc)
+		}
+	case info.State == "exited" && info.Signal != "":
+		text = "was killed by " + info.Signal
+	case info.State == "exited":
+		text = fmt.Sprintf("exited with code %d", info.ExitCode)
+	default:
+		return
+	}
+	logEvent(info.Rank, text, t)
+}
+
+// logCollectiveMismatch logs a call to a collective by a rank which did
+// not make an earlier call which other ranks made, since every rank must
+// call the collectives in the same order.
+func logCollectiveMismatch(info utils.CollectiveInfo, t *tui.TUI) {
+	var text string
+	collectiveCallList.mux.Lock()
+	for c_ := collectiveCallList.calls.Front(); c_ != nil; c_ = c_.Next() {
+		c := c_.Value.(*CollectiveCall)
+		if _, ok := c.callers[info.Rank]; !ok && c.funcName != info.FunctionName {
+			var callers []int
+			for rank := range c.callers {
+				callers = append(callers, rank)
+			}
+			text = fmt.Sprintf("called %s while %s wait in %s", info.FunctionName, formatRanks(callers), c.funcName)
+			break
+		}
+	}
+	collectiveCallList.mux.Unlock()
+	if text != "" {
+		logEvent(info.Rank, text, t)
+	}
+}
+
+// rankLost logs that the connection to a rank was lost, which the status
+// bar tells from then on, and stops waiting for the rank to answer.
+func rankLost(rank int, t *tui.TUI) {
+	session.mux.Lock()
+	session.lost[rank] = true
+	session.mux.Unlock()
+	dropRequests(rank)
+	logEvent(rank, "disconnected", t)
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"git.cse.iitk.ac.in/ssaha/parallel-debugger/pd-server/tui"
+)
+
+var historyFile = flag.String("history", "", "File in which the command history is kept (default: one per program or directory under ~/.config/pd/history)")
+
+// maxHistory is how many commands are loaded from the history file, and
+// how many it holds at most once it is trimmed.
+const maxHistory = 1000
+
+// project names what is being debugged, so that every program keeps its own
+// history. runMain sets it to the path of the program, otherwise it is the
+// directory pd-server runs in.
+var project string
+
+var history struct {
+	last string
+	mux  sync.Mutex
+}
+
+// fileErrors holds what could not be done with the files pd keeps, which
+// is told once, as it would likely fail again and again.
+var fileErrors struct {
+	told map[string]bool
+	mux  sync.Mutex
+}
+
+// reportFileError tells in the panes, since the UI owns the terminal, that
+// something could not be done with the files pd keeps, unless that was
+// told already.
+func reportFileError(what string, err error, t *tui.TUI) {
+	fileErrors.mux.Lock()
+	defer fileErrors.mux.Unlock()
+	if fileErrors.told[what] {
+		return
+	}
+	if fileErrors.told == nil {
+		fileErrors.told = make(map[string]bool)
+	}
+	fileErrors.told[what] = true
+	go t.ShowMessagesAll(fmt.Sprintf("%s: %v", what, err))
+}
+
+// configDir gives the directory where pd keeps its files, following the
+// XDG base directory specification.
+func configDir() (string, error) {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "pd"), nil
+	}
+	home := os.Getenv("HOME")
+	if home == "" {
+		return "", fmt.Errorf("neither $XDG_CONFIG_HOME nor $HOME is set")
+	}
+	return filepath.Join(home, ".config", "pd"), nil
+}
+
+// projectFile gives the path of the file in which the project keeps its
+// data of a kind, e.g. <config>/history/a.out-1f2e3d4c. The base name of the
+// project comes first to make the files easy to tell apart, and the hash of
+// its full path keeps two projects with the same name apart.
+func projectFile(kind string) (string, error) {
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	name := project
+	if name == "" {
+		if name, err = os.Getwd(); err != nil {
+			return "", err
+		}
+	}
+	sum := sha1.Sum([]byte(name))
+	base := strings.Map(func(r rune) rune {
+		if r == '/' || r == os.PathSeparator || r < ' ' {
+			return '_'
+		}
+		return r
+	}, filepath.Base(name))
+	return filepath.Join(dir, kind, fmt.Sprintf("%s-%x", base, sum[:4])), nil
+}
+
+func historyPath() (string, error) {
+	if *historyFile != "" {
+		return *historyFile, nil
+	}
+	return projectFile("history")
+}
+
+// loadHistory gives the last commands in the history file. A file which
+// has grown to twice maxHistory is trimmed back to maxHistory.
+func loadHistory(t *tui.TUI) []string {
+	path, err := historyPath()
+	if err != nil {
+		reportFileError("No command history", err, t)
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			reportFileError("Could not read the command history", err, t)
+		}
+		return nil
+	}
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	f.Close()
+
+	if len(lines) >= 2*maxHistory {
+		lines = lines[len(lines)-maxHistory:]
+		writeHistory(path, lines, t)
+	} else if len(lines) > maxHistory {
+		lines = lines[len(lines)-maxHistory:]
+	}
+	history.mux.Lock()
+	if len(lines) != 0 {
+		history.last = lines[len(lines)-1]
+	}
+	history.mux.Unlock()
+	return lines
+}
+
+func writeHistory(path string, lines []string, t *tui.TUI) {
+	tmp := path + ".tmp"
+	err := ioutil.WriteFile(tmp, []byte(strings.Join(lines, "\n")+"\n"), 0600)
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		reportFileError("Could not trim the command history", err, t)
+	}
+}
+
+// saveHistory appends a command to the history file right away, since the
+// server exits without any chance to write it later. A command which
+// repeats the one before it is kept once.
+func saveHistory(input string, t *tui.TUI) {
+	input = strings.TrimSpace(input)
+	history.mux.Lock()
+	defer history.mux.Unlock()
+	if input == "" || input == history.last {
+		return
+	}
+	history.last = input
+
+	path, err := historyPath()
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		reportFileError("Could not save the command history", err, t)
+		return
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		reportFileError("Could not save the command history", err, t)
+		return
+	}
+	fmt.Fprintln(f, input)
+	f.Close()
+}
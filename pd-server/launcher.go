@@ -0,0 +1,261 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"git.cse.iitk.ac.in/ssaha/parallel-debugger/utils"
+)
+
+// cleanups holds the functions to be run when the session ends, either
+// because the user quit or because every client went away.
+var cleanups struct {
+	fns []func()
+	mux sync.Mutex
+}
+
+func atSessionEnd(fn func()) {
+	cleanups.mux.Lock()
+	cleanups.fns = append(cleanups.fns, fn)
+	cleanups.mux.Unlock()
+}
+
+func runCleanups() {
+	cleanups.mux.Lock()
+	fns := cleanups.fns
+	cleanups.fns = nil
+	cleanups.mux.Unlock()
+
+	for _, fn := range fns {
+		fn()
+	}
+}
+
+// fatalf tears down what was started for the session, e.g. the job or the
+// gdbs attached to it, before exiting with a message.
+func fatalf(format string, v ...interface{}) {
+	runCleanups()
+	log.Fatalf(format, v...)
+}
+
+// runMain launches the MPI job itself, with pd-client wrapped around every
+// rank, and runs a single debugging session for it:
+//
+//	pd-server run [launcher options] -- ./binary args
+//
+// The clients learn where the server is through PD_SERVER.
+func runMain(args []string) {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	launcher := flags.String("launcher", "mpiexec", "Command used to launch the job, e.g. mpirun or srun, including any extra arguments")
+	nFlag := flags.String("nflag", "-n", "Flag of the launcher which sets the number of ranks")
+	n := flags.Int("n", 2, "Number of ranks")
+	client := flags.String("client", "", "Path to pd-client (default: pd-client next to pd-server, or in PATH)")
+	host := flags.String("host", "", "Address of this machine as seen by the ranks (default: hostname)")
+	logFile := flags.String("log", os.DevNull, "File to which the output of the launcher is written")
+	flags.Usage = func() {
+		fmt.Printf("Usage %s run [launcher options] -- <filename> [args...]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() < 1 {
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	if *host == "" {
+		hostname, err := os.Hostname()
+		utils.CheckError(err)
+		*host = hostname
+	}
+	if *client == "" {
+		*client = findClient()
+	}
+	if binary, err := filepath.Abs(flags.Arg(0)); err == nil {
+		project = binary
+	}
+
+	ln, port := listenOnFreePort()
+
+	launcherArgs := strings.Fields(*launcher)
+	launcherArgs = append(launcherArgs, *nFlag, fmt.Sprintf("%d", *n), *client)
+	launcherArgs = append(launcherArgs, flags.Args()...)
+
+	cmd := exec.Command(launcherArgs[0], launcherArgs[1:]...)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("PD_SERVER=%s:%d", *host, port))
+	if os.Getenv("PD_FILE_DIR") == "" {
+		// mpic.so is built alongside pd-client.
+		cmd.Env = append(cmd.Env, fmt.Sprintf("PD_FILE_DIR=%s", filepath.Dir(*client)))
+	}
+	runSession(ln, []*exec.Cmd{cmd}, *logFile)
+}
+
+// attachJobMain attaches to every rank of a running job, given as host:pid
+// pairs on the command line or in a file, and runs a single debugging
+// session for it. Ranks on other hosts are reached through -rsh.
+func attachJobMain(args []string) {
+	flags := flag.NewFlagSet("attach-job", flag.ExitOnError)
+	client := flags.String("client", "", "Path to pd-client on every host (default: pd-client next to pd-server, or in PATH)")
+	host := flags.String("host", "", "Address of this machine as seen by the ranks (default: hostname)")
+	rsh := flags.String("rsh", "ssh", "Command used to run pd-client on other hosts")
+	pidFile := flags.String("f", "", "File with one host:pid pair per line")
+	logFile := flags.String("log", os.DevNull, "File to which the output of the clients is written")
+	flags.Usage = func() {
+		fmt.Printf("Usage %s attach-job [options] [<host>:<pid>...]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	pairs := flags.Args()
+	if *pidFile != "" {
+		data, err := ioutil.ReadFile(*pidFile)
+		utils.CheckError(err)
+		pairs = append(pairs, strings.Fields(string(data))...)
+	}
+	if len(pairs) == 0 {
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	if *host == "" {
+		hostname, err := os.Hostname()
+		utils.CheckError(err)
+		*host = hostname
+	}
+	if *client == "" {
+		*client = findClient()
+	}
+
+	ln, port := listenOnFreePort()
+	server := fmt.Sprintf("%s:%d", *host, port)
+
+	var cmds []*exec.Cmd
+	for _, pair := range pairs {
+		pairSplit := strings.SplitN(pair, ":", 2)
+		if len(pairSplit) != 2 {
+			log.Fatalf("Expected <host>:<pid>, got %s\n", pair)
+		}
+		pidHost, pid := pairSplit[0], pairSplit[1]
+
+		clientArgs := []string{*client, "attach", server, pid}
+		if !isLocalHost(pidHost) {
+			clientArgs = append(append(strings.Fields(*rsh), pidHost), clientArgs...)
+		}
+		cmds = append(cmds, exec.Command(clientArgs[0], clientArgs[1:]...))
+	}
+	runSession(ln, cmds, *logFile)
+}
+
+// isLocalHost tells if host names this machine.
+func isLocalHost(host string) bool {
+	hostname, _ := os.Hostname()
+	return host == "" || host == "localhost" || host == "127.0.0.1" || host == hostname
+}
+
+func listenOnFreePort() (ln net.Listener, port int) {
+	ln, err := net.Listen("tcp", ":0")
+	utils.CheckError(err)
+	port = ln.Addr().(*net.TCPAddr).Port
+	log.Printf("Server running on port %d\n", port)
+	return
+}
+
+// runSession starts the commands which bring up the clients, and runs a
+// debugging session once they have all connected. The commands are torn
+// down when the session ends.
+func runSession(ln net.Listener, cmds []*exec.Cmd, logFile string) {
+	out, err := os.OpenFile(logFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	utils.CheckError(err)
+	defer out.Close()
+
+	exited := make(chan bool, len(cmds))
+	for _, cmd := range cmds {
+		cmd := cmd
+		cmd.Stdout = out
+		cmd.Stderr = out
+		// Run every command in its own process group, so that everything
+		// it started can be torn down at once.
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+		log.Printf("Launching %s\n", strings.Join(cmd.Args, " "))
+		if err := cmd.Start(); err != nil {
+			fatalf("Fatal error: %s\n", err.Error())
+		}
+
+		cmdExited := make(chan bool)
+		go func(cmd *exec.Cmd) {
+			err := cmd.Wait()
+			if err != nil {
+				log.Printf("%s exited: %s\n", cmd.Args[0], err.Error())
+			}
+			close(cmdExited)
+			exited <- true
+		}(cmd)
+		atSessionEnd(func() { stopJob(cmd, cmdExited) })
+	}
+
+	connected := make(chan net.Conn)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				fatalf("Fatal error: %s\n", err.Error())
+			}
+			connected <- conn
+		}
+	}()
+
+	for {
+		select {
+		case conn := <-connected:
+			if handleConnection(conn) {
+				runCleanups()
+				return
+			}
+		case <-exited:
+			fatalf("A client exited before all the ranks connected, see %s\n", logFile)
+		}
+	}
+}
+
+// stopJob terminates the launcher and everything it started, killing them
+// if they are still around after a while.
+func stopJob(cmd *exec.Cmd, exited chan bool) {
+	pgid := -cmd.Process.Pid
+	syscall.Kill(pgid, syscall.SIGTERM)
+	select {
+	case <-exited:
+	case <-time.After(5 * time.Second):
+		syscall.Kill(pgid, syscall.SIGKILL)
+	}
+}
+
+// findClient looks for pd-client next to the pd-server executable, and then
+// in PATH.
+func findClient() string {
+	if exe, err := os.Executable(); err == nil {
+		for _, dir := range []string{filepath.Dir(exe), filepath.Join(filepath.Dir(exe), "..", "pd-client")} {
+			path := filepath.Join(dir, "pd-client")
+			if _, err := os.Stat(path); err == nil {
+				return path
+			}
+		}
+	}
+
+	path, err := exec.LookPath("pd-client")
+	if err != nil {
+		log.Fatalln("Could not find pd-client, use -client")
+	}
+	abs, err := filepath.Abs(path)
+	utils.CheckError(err)
+	return abs
+}
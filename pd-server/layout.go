@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"git.cse.iitk.ac.in/ssaha/parallel-debugger/pd-server/tui"
+)
+
+// paneRanks parses the ranks given to the pane commands, e.g. 0,4..8, which
+// may also be written as r=0,4..8 or [r=0,4..8] like for pdb_ commands.
+func paneRanks(spec string) []int {
+	return parseRankGroups(strings.TrimPrefix(strings.Trim(spec, "[]"), "r="))
+}
+
+// setLayout handles pdb_layout, which arranges the panes:
+//
+//	pdb_layout horizontal|vertical|tabbed|maximized
+//	pdb_layout grid [<rows>x<cols>]
+//
+// Either size of the grid may be 0, or the size left out, to fit the panes.
+func setLayout(args []string, t *tui.TUI) {
+	if len(args) == 0 || len(args) > 2 || (len(args) == 2 && args[0] != "grid") {
+		go t.ShowMessagesAll("Usage: pdb_layout horizontal|vertical|tabbed|maximized, or pdb_layout grid [<rows>x<cols>], e.g. pdb_layout grid 2x3")
+		return
+	}
+	rows, cols := 0, 0
+	if len(args) == 2 {
+		size := strings.Split(args[1], "x")
+		var err error
+		if len(size) == 2 {
+			if rows, err = strconv.Atoi(size[0]); err == nil {
+				cols, err = strconv.Atoi(size[1])
+			}
+		}
+		if len(size) != 2 || err != nil || rows < 0 || cols < 0 {
+			go t.ShowMessagesAll(fmt.Sprintf("Bad grid size %s, e.g. 2x3 is 2 rows of 3 panes", args[1]))
+			return
+		}
+	}
+	if err := t.SetLayout(args[0], rows, cols); err != nil {
+		go t.ShowMessagesAll(fmt.Sprintf("Could not change the layout: %s", err))
+	}
+}
+
+func layoutPath() (string, error) {
+	return projectFile("layouts")
+}
+
+// loadLayout reads the layout saved for the project, if any.
+func loadLayout(t *tui.TUI) (tui.Layout, bool) {
+	var l tui.Layout
+	path, err := layoutPath()
+	if err != nil {
+		return l, false
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			reportFileError("Could not read the layout", err, t)
+		}
+		return l, false
+	}
+	if err := json.Unmarshal(data, &l); err != nil {
+		reportFileError("Could not read the layout in "+path, err, t)
+		return l, false
+	}
+	return l, true
+}
+
+// saveLayout saves the layout of the project, to be restored by the next
+// session.
+func saveLayout(l tui.Layout, t *tui.TUI) {
+	path, err := layoutPath()
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(path), 0700)
+	}
+	var data []byte
+	if err == nil {
+		data, err = json.Marshal(l)
+	}
+	if err == nil {
+		err = ioutil.WriteFile(path, append(data, '\n'), 0600)
+	}
+	if err != nil {
+		reportFileError("Could not save the layout", err, t)
+	}
+}
@@ -4,20 +4,30 @@ import (
 	"bufio"
 	"container/list"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
 	"net"
+	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"git.cse.iitk.ac.in/ssaha/parallel-debugger/pd-server/tui"
 	"git.cse.iitk.ac.in/ssaha/parallel-debugger/utils"
 	tuiGo "github.com/marcusolsson/tui-go"
 )
 
+var stopOnCrash = flag.Bool("stop-on-crash", false, "Stop every other rank when a rank crashes")
+
 var connections = make(map[int]*net.Conn)
 
+// readers holds the buffered reader of every connection. The handshake is
+// read through it, so it may already hold messages sent right after it.
+var readers = make(map[int]*bufio.Reader)
+
 type CollectiveCall struct {
 	funcName string
 	callers  map[int]*utils.CollectiveInfo
@@ -29,11 +39,37 @@ var collectiveCallList struct {
 }
 
 func main() {
+	flag.Parse()
+
 	// Initialize some structs.
 	collectiveCallList.mux.Lock()
 	collectiveCallList.calls = list.New()
 	collectiveCallList.mux.Unlock()
 
+	if flag.Arg(0) == "replay" {
+		replayMain(flag.Args()[1:])
+		return
+	}
+	if flag.Arg(0) == "attach" {
+		attachMain(flag.Args()[1:])
+		return
+	}
+	if os.Getenv(daemonEnv) != "" {
+		serveDaemon()
+	} else if *daemon {
+		startDaemon()
+	}
+	openRecording()
+
+	switch flag.Arg(0) {
+	case "run":
+		runMain(flag.Args()[1:])
+		return
+	case "attach-job":
+		attachJobMain(flag.Args()[1:])
+		return
+	}
+
 	port := 8080
 	host := "0.0.0.0"
 
@@ -65,9 +101,14 @@ func parseInput(input string) (command string, ranks []int) {
 	// Split on any number of spaces or tabs, not just single " ".
 	commandSegments := strings.Fields(input)
 
-	// Get the last bit with r=g,g,g,g
+	// Get the last bit with r=g,g,g,g, if there is one. Anything else with
+	// a =, e.g. the condition of pdb_break f if i==3, is part of the command.
 	rankSpecString := strings.TrimSpace(commandSegments[len(commandSegments)-1])
 	rankSpecString = strings.Trim(rankSpecString, "[]")
+	if !strings.HasPrefix(rankSpecString, "r=") {
+		command = strings.TrimPrefix(input, "pdb_")
+		return
+	}
 
 	// Get the <command> by stripping away pdb_ and [r=g,g,g,g]
 	commandSegments = commandSegments[:len(commandSegments)-1]
@@ -77,15 +118,15 @@ func parseInput(input string) (command string, ranks []int) {
 	// Using r=g,g,g,g, come up with a list of ranks that have to be
 	// sent the command. Note that existence of these ranks is not
 	// guaranteed, we just make up the list of ranks based on input.
-	rankListPrefix := strings.Split(rankSpecString, "=")[0]
-	if rankListPrefix != "r" {
-		log.Println("Rank list prefix other than r is not supported, so sending to all ranks silently.")
-		return
-	}
+	ranks = parseRankGroups(strings.Split(rankSpecString, "=")[1])
+	return
+}
 
-	rankGroups := strings.Split(strings.Split(rankSpecString, "=")[1], ",")
+// parseRankGroups parses a list of ranks like g,g,g,g where g can be a
+// single rank, or something like x..y, which stands for x up to y-1.
+func parseRankGroups(groups string) []int {
 	rankSet := make(map[int]bool) // Go's approximation of a set.
-	for _, rg := range rankGroups {
+	for _, rg := range strings.Split(groups, ",") {
 		// First check if g is one single rank.
 		rank, err := strconv.Atoi(rg)
 		if err != nil {
@@ -113,17 +154,20 @@ func parseInput(input string) (command string, ranks []int) {
 		}
 	}
 
-	ranks = make([]int, len(rankSet))
-	i := 0
+	ranks := make([]int, 0, len(rankSet))
 	for k := range rankSet {
-		ranks[i] = k
-		i++
+		ranks = append(ranks, k)
 	}
-	return
+	sort.Ints(ranks)
+	return ranks
 }
 
-func handleConnection(c net.Conn) {
-	status, err := bufio.NewReader(c).ReadString('\n')
+// handleConnection registers a client. Once every client of the job is
+// connected, it runs the debugging session and returns true when the
+// session is over.
+func handleConnection(c net.Conn) (sessionDone bool) {
+	reader := bufio.NewReader(c)
+	status, err := reader.ReadString('\n')
 	utils.CheckError(err)
 
 	status = strings.TrimSpace(status)
@@ -134,60 +178,204 @@ func handleConnection(c net.Conn) {
 	log.Printf("Processing client with rank = %d, world size = %d\n", rank, wSize)
 
 	connections[rank] = &c
+	readers[rank] = reader
 
 	if wSize == len(connections) {
 		fmt.Printf("All the clients are connected\n")
+		recordSession(wSize)
+		resetTranscript()
+		resetBreakpoints()
+		resetRequests()
+		resetRankVars()
+		resetSources()
+		resetCompletions()
+		resetOutput()
+		resetSession()
+		nameSession(projectName())
 		for _, v := range connections {
 			fmt.Fprintf(*v, "COMMAND:All clients, including you, are connected\n")
 		}
 		t := tui.NewTUI(connections)
+		limitHistory(t)
 		t.DrawUI()
+		setScreen(true)
+		if l, ok := loadLayout(t); ok {
+			t.Update(func() { t.RestoreLayout(l) })
+		}
+		t.OnLayout(func(l tui.Layout) { go saveLayout(l, t) })
+		t.OnQuit(runCleanups)
+		t.OnFocus(func(rank int) { go focusSource(rank, t) })
+		t.OnComplete(completeInput)
+		t.SetCmdHistory(loadHistory(t))
 		t.ShowMessagesAll("You are connected")
 		t.Input.OnSubmit(func(e *tuiGo.Entry) {
 			// t.ShowUserInputAll(e.Text())
 			takeUserInput(e.Text(), t)
 			t.AddToCmdHistory(e.Text())
+			saveHistory(e.Text(), t)
 			t.Input.SetText("")
 		})
 
+		statusDone := make(chan bool)
+		go watchStatus(t, statusDone)
 		processClient := make(chan bool)
 		go processClientMessage(wSize, processClient, t)
 		<-processClient
+		close(statusDone)
+		flushSpills()
+		setScreen(false)
 		connections = make(map[int]*net.Conn)
+		readers = make(map[int]*bufio.Reader)
+		resetRankStates()
+		return true
 	}
-
+	return false
 }
 
 func takeUserInput(input string, t *tui.TUI) {
+	_, ranks := parseInput(input)
+	recordCommand(input, ranks)
+
+	if takeLocalInput(input, t) || takeSourceInput(input, t) {
+		return
+	}
+	if pending := barrierPending(); len(pending) != 0 && !strings.HasPrefix(input, "pdb_interrupt") {
+		go t.ShowMessagesAll(fmt.Sprintf("Waiting for %s to stop, use pdb_interrupt to stop them", formatRanks(pending)))
+		return
+	}
+	if takeBreakpointInput(input, t) || takeVarInput(input, t) || takeStepInput(input, t) || takeCollectiveInput(input, t) {
+		transcriptCommand(input, ranks, time.Now())
+		return
+	}
+	if strings.HasPrefix(input, "pdb_trackcoll") {
+		transcriptCommand(input, nil, time.Now())
+		toggleCollective(strings.Split(input, " ")[1])
+	} else {
+		transcriptCommand(input, ranks, time.Now())
+		command, ranks := parseInput(input)
+		commands, err := sendCommandTo(command, ranks)
+		if err != nil {
+			go t.ShowMessagesAll(err.Error())
+			return
+		}
+		showCommands(command, commands, ranks, t)
+	}
+}
+
+// takeLocalInput runs the commands which are handled by the server alone,
+// and tells if input was one of them.
+func takeLocalInput(input string, t *tui.TUI) bool {
 	if input == "pdb_listcoll" {
 		calls := pendingCollectiveInfo()
 		go prettyPrintCollectiveInfo(calls, t)
+	} else if input == "pdb_status" {
+		go prettyPrintStatus(t)
+	} else if input == "pdb_summary" {
+		go prettyPrintSummary(t)
+	} else if input == "pdb_events" {
+		go t.ShowEvents()
+	} else if strings.HasPrefix(input, "pdb_export") {
+		fields := strings.Fields(input)
+		if len(fields) != 2 {
+			go t.ShowMessagesAll("Usage: pdb_export <file>, where a file ending with .md gets Markdown and any other HTML")
+		} else {
+			go exportTranscript(fields[1], t)
+		}
+	} else if strings.HasPrefix(input, "pdb_show") {
+		fields := strings.Fields(input)
+		if len(fields) != 2 {
+			go t.ShowMessagesAll("Usage: pdb_show <ranks>, e.g. pdb_show 0,4..8 to show the panes of 0 and 4 to 7")
+		} else {
+			t.Show(paneRanks(fields[1]))
+		}
+	} else if strings.HasPrefix(input, "pdb_diff") {
+		go diffRanks(strings.Fields(input)[1:], t)
+	} else if strings.HasPrefix(input, "pdb_layout") {
+		setLayout(strings.Fields(input)[1:], t)
+	} else if strings.HasPrefix(input, "/") {
+		searchPanes(strings.TrimPrefix(input, "/"), t)
+	} else if strings.HasPrefix(input, "pdb_filter") {
+		filterPanes(strings.Fields(input)[1:], t)
 	} else if input == "quit" {
 		t.Quit()
-	} else if strings.HasPrefix(input, "swap") {
-		vals := strings.Split(input, " ")
-		newRank, _ := strconv.Atoi(vals[1])
-		oldRank, _ := strconv.Atoi(vals[2])
-		t.Swap(newRank, oldRank)
-	} else if strings.HasPrefix(input, "add") {
-		vals := strings.Split(input, " ")
-		rank, _ := strconv.Atoi(vals[1])
-		t.Add(rank)
-	} else if strings.HasPrefix(input, "remove") {
-		vals := strings.Split(input, " ")
-		rank, _ := strconv.Atoi(vals[1])
-		t.Remove(rank)
-	} else if strings.HasPrefix(input, "pdb_trackcoll") {
-		toggleCollective(strings.Split(input, " ")[1])
+	} else if input == "pdb_detach" {
+		if !detachClient() {
+			go t.ShowMessagesAll("Only a session started with pd-server -daemon can be detached, quit ends this one")
+		}
+	} else if firstWord(input) == "swap" {
+		vals := strings.Fields(input)
+		if len(vals) != 3 {
+			go t.ShowMessagesAll("Usage: swap <new ranks> <old ranks>, e.g. swap 4..6 0,1 to show the panes of 4 and 5 in place of those of 0 and 1")
+		} else {
+			t.Swap(paneRanks(vals[1]), paneRanks(vals[2]))
+		}
+	} else if firstWord(input) == "add" {
+		vals := strings.Fields(input)
+		if len(vals) != 2 {
+			go t.ShowMessagesAll("Usage: add <ranks>, e.g. add 2,4..6")
+		} else {
+			t.Add(paneRanks(vals[1]))
+		}
+	} else if firstWord(input) == "remove" {
+		vals := strings.Fields(input)
+		if len(vals) != 2 {
+			go t.ShowMessagesAll("Usage: remove <ranks>, e.g. remove 2,4..6")
+		} else {
+			t.Remove(paneRanks(vals[1]))
+		}
 	} else {
-		command, ranks := parseInput(input)
-		sendCommandTo(command, ranks)
-		t.ShowUserInputClients(command, ranks)
+		return false
 	}
+	return true
 }
 
-func sendCommandTo(message string, ranks []int) {
-	sendMsgTo(message, ranks, "RUN")
+// firstWord gives the first word of input, which names the command. gdb
+// has commands which start like those of pd, e.g. add-symbol-file.
+func firstWord(input string) string {
+	fields := strings.Fields(input)
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
+
+// sendCommandTo expands a command for every rank in ranks, or every
+// connected rank if ranks is nil, and sends it to them. Nothing is sent if
+// it cannot be expanded for some rank. It gives back the command as sent
+// to every rank.
+func sendCommandTo(message string, ranks []int) (map[int]string, error) {
+	if ranks == nil {
+		ranks = allRanks()
+	}
+	commands := make(map[int]string)
+	for _, rank := range ranks {
+		if _, ok := connections[rank]; !ok {
+			continue
+		}
+		command, err := expandTemplate(message, rank)
+		if err != nil {
+			return nil, fmt.Errorf("Could not expand %s for rank %d: %s", message, rank, err.Error())
+		}
+		commands[rank] = command
+	}
+	for rank, command := range commands {
+		sendMsgTo(command, []int{rank}, "RUN")
+	}
+	return commands, nil
+}
+
+// showCommands shows a command in the panes of the ranks it was sent to,
+// as it was expanded for every rank.
+func showCommands(message string, commands map[int]string, ranks []int, t *tui.TUI) {
+	for _, command := range commands {
+		if command != message {
+			for rank, command := range commands {
+				t.ShowUserInputClients(command, []int{rank})
+			}
+			return
+		}
+	}
+	t.ShowUserInputClients(message, ranks)
 }
 
 func toggleCollective(coll string) {
@@ -229,13 +417,15 @@ func processClientMessage(wSize int, processClientDone chan bool, t *tui.TUI) {
 		// handling output of every client in a separate go routine
 		go func(r int, c *net.Conn) {
 			defer waitGroup.Done()
-			scanner := bufio.NewScanner(*c)
+			scanner := utils.NewMessageScanner(readers[r])
 			for scanner.Scan() {
 				utils.CheckError(scanner.Err())
 				line := scanner.Text()
 				lineSplit := strings.SplitN(line, ":", 2)
+				recordClientMessage(r, lineSplit[0], lineSplit[1])
 				handleClientMessage(lineSplit[0], lineSplit[1], r, t)
 			}
+			rankLost(r, t)
 		}(r, c)
 	}
 
@@ -247,18 +437,40 @@ func handleClientMessage(cat string, msg string, rank int, t *tui.TUI) {
 	switch cat {
 	case "CONSOLE":
 		// fmt.Printf("[rank %d] %s\n", rank, msg)
-		t.ShowMessagesClient(msg, rank)
+		showOutput(rank, msg, t)
 	case "ERROR":
 		// fmt.Printf("[rank %d] (!) %s\n", rank, msg)
+		spill(rank, "(!) "+msg, t)
+		transcriptOutput(rank, "(!) "+msg)
 		t.ShowMessagesClient(msg, rank)
+		logEvent(rank, "error: "+msg, t)
+		barrierFailed(rank)
 	case "COLLECTIVE":
 		var coll utils.CollectiveInfo
 		_ = json.Unmarshal([]byte(msg), &coll)
-		trackCollective(coll)
+		logCollectiveMismatch(coll, t)
+		for _, caller := range trackCollective(coll) {
+			refreshRankState(caller, t)
+		}
+	case "STATE":
+		var state utils.StateInfo
+		_ = json.Unmarshal([]byte(msg), &state)
+		updateRankState(state, t)
+	case "BREAKPOINT":
+		var bp utils.Breakpoint
+		_ = json.Unmarshal([]byte(msg), &bp)
+		breakpointModified(rank, bp, t)
+	case "RESPONSE":
+		handleResponse(rank, msg)
+	case "HELD":
+		heldOutput(rank, msg, t)
 	}
 }
 
-func trackCollective(info utils.CollectiveInfo) {
+// trackCollective adds a call to a collective to the calls which not every
+// rank made yet. Once every rank made it, it gives back the ranks which
+// made it.
+func trackCollective(info utils.CollectiveInfo) (completed []int) {
 	collectiveCallList.mux.Lock()
 	defer collectiveCallList.mux.Unlock()
 	cl := collectiveCallList.calls
@@ -277,6 +489,9 @@ func trackCollective(info utils.CollectiveInfo) {
 	}
 
 	if toRemove != nil {
+		for rank := range toRemove.Value.(*CollectiveCall).callers {
+			completed = append(completed, rank)
+		}
 		cl.Remove(toRemove)
 	}
 
@@ -289,6 +504,20 @@ func trackCollective(info utils.CollectiveInfo) {
 		}
 		cl.PushBack(c)
 	}
+	return
+}
+
+// inPendingCollective tells if a rank called a collective which not every
+// rank called yet.
+func inPendingCollective(rank int) bool {
+	collectiveCallList.mux.Lock()
+	defer collectiveCallList.mux.Unlock()
+	for c_ := collectiveCallList.calls.Front(); c_ != nil; c_ = c_.Next() {
+		if _, ok := c_.Value.(*CollectiveCall).callers[rank]; ok {
+			return true
+		}
+	}
+	return false
 }
 
 func pendingCollectiveInfo() (calls []CollectiveCall) {
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"git.cse.iitk.ac.in/ssaha/parallel-debugger/pd-server/tui"
+)
+
+var paneLines = flag.Int("pane-lines", 5000, "Messages of every rank kept for its pane and for pdb_export, or 0 to keep all of them")
+var spillDir = flag.String("spill-dir", "", "Directory in which all the output the server gets from every rank is kept, in rank-<n>.log")
+var outputRate = flag.Int("rate", 200, "Lines of output per second shown for every rank, beyond which they are suppressed, or 0 for no limit")
+
+// outputLimit is a token bucket which lets a rank print outputRate lines a
+// second, and as many at once. Once it is empty, the client is told to
+// stop sending output for a second, and the lines which still arrive are
+// suppressed.
+type outputLimit struct {
+	tokens     float64
+	last       time.Time
+	suppressed int
+	paused     bool
+}
+
+// spillFile is the spill file of a rank. Writing every line to the file
+// while holding the lock would hold up the output of all the ranks, so the
+// lines are buffered and flushed a second later.
+type spillFile struct {
+	f *os.File
+	w *bufio.Writer
+}
+
+var output struct {
+	limits map[int]*outputLimit
+	spills map[int]*spillFile
+	// flushing is set while the spill files are due to be flushed.
+	flushing bool
+	mux      sync.Mutex
+}
+
+// resetOutput closes the spill files of a finished session.
+func resetOutput() {
+	output.mux.Lock()
+	for _, s := range output.spills {
+		if s != nil {
+			s.w.Flush()
+			s.f.Close()
+		}
+	}
+	output.limits = make(map[int]*outputLimit)
+	output.spills = make(map[int]*spillFile)
+	output.flushing = false
+	output.mux.Unlock()
+}
+
+// limitHistory limits how much output the panes keep, and points them to
+// the spill files, which are flushed when the user quits.
+func limitHistory(t *tui.TUI) {
+	t.LimitHistory(*paneLines, func(rank int) string {
+		if *spillDir == "" {
+			return ""
+		}
+		return spillPath(rank)
+	})
+	t.OnQuit(func() { flushSpills() })
+}
+
+func spillPath(rank int) string {
+	return filepath.Join(*spillDir, fmt.Sprintf("rank-%d.log", rank))
+}
+
+// showOutput shows a line of output of a rank, unless the rank prints too
+// fast. Every line which reaches the server is kept in the spill file of
+// the rank either way, but those which the client held back while its
+// output was paused are only counted, see heldOutput.
+func showOutput(rank int, line string, t *tui.TUI) {
+	spill(rank, line, t)
+	if !admitOutput(rank, t) {
+		return
+	}
+	transcriptOutput(rank, line)
+	t.ShowMessagesClient(line, rank)
+}
+
+// spill appends a line to the spill file of a rank, which is created the
+// first time.
+func spill(rank int, line string, t *tui.TUI) {
+	if *spillDir == "" {
+		return
+	}
+	output.mux.Lock()
+	defer output.mux.Unlock()
+	s, ok := output.spills[rank]
+	if !ok {
+		err := os.MkdirAll(*spillDir, 0755)
+		var f *os.File
+		if err == nil {
+			f, err = os.Create(spillPath(rank))
+		}
+		if err != nil {
+			reportFileError("Could not keep all the output in "+*spillDir, err, t)
+		} else {
+			s = &spillFile{f: f, w: bufio.NewWriter(f)}
+		}
+		// A file which could not be created is not tried again.
+		output.spills[rank] = s
+	}
+	if s == nil {
+		return
+	}
+	fmt.Fprintln(s.w, line)
+	if !output.flushing {
+		output.flushing = true
+		time.AfterFunc(time.Second, func() {
+			if err := flushSpills(); err != nil {
+				reportFileError("Could not keep all the output in "+*spillDir, err, t)
+			}
+		})
+	}
+}
+
+// flushSpills writes what was buffered for the spill files to them.
+func flushSpills() (err error) {
+	output.mux.Lock()
+	defer output.mux.Unlock()
+	for _, s := range output.spills {
+		if s == nil {
+			continue
+		}
+		if flushErr := s.w.Flush(); err == nil {
+			err = flushErr
+		}
+	}
+	output.flushing = false
+	return
+}
+
+// admitOutput tells whether a line of output of a rank is to be shown.
+func admitOutput(rank int, t *tui.TUI) bool {
+	if *outputRate <= 0 {
+		return true
+	}
+	rate := float64(*outputRate)
+	now := time.Now()
+
+	output.mux.Lock()
+	l, ok := output.limits[rank]
+	if !ok {
+		l = &outputLimit{tokens: rate, last: now}
+		output.limits[rank] = l
+	}
+	l.tokens += now.Sub(l.last).Seconds() * rate
+	if l.tokens > rate {
+		l.tokens = rate
+	}
+	l.last = now
+	if l.tokens >= 1 && !l.paused {
+		l.tokens--
+		output.mux.Unlock()
+		return true
+	}
+
+	l.suppressed++
+	pause := !l.paused
+	l.paused = true
+	output.mux.Unlock()
+
+	// A client which is slow to read must not hold up the output of the
+	// others, so it is told without the lock.
+	if pause {
+		sendFlow(rank, "pause")
+		time.AfterFunc(time.Second, func() { resumeOutput(rank, l, t) })
+	}
+	return false
+}
+
+// resumeOutput tells how many lines of a rank were suppressed, and lets
+// the client send its output again.
+func resumeOutput(rank int, l *outputLimit, t *tui.TUI) {
+	output.mux.Lock()
+	if output.limits[rank] != l {
+		// The session is over.
+		output.mux.Unlock()
+		return
+	}
+	suppressed := l.suppressed
+	l.suppressed = 0
+	l.paused = false
+	output.mux.Unlock()
+
+	if suppressed != 0 {
+		showSuppressed(rank, fmt.Sprintf("(%d lines suppressed, rank %d prints more than %d lines a second)", suppressed, rank, *outputRate), t)
+	}
+	sendFlow(rank, "resume")
+}
+
+// heldOutput handles HELD, with which a client tells how many lines it
+// held back while its output was paused.
+func heldOutput(rank int, msg string, t *tui.TUI) {
+	held, err := strconv.Atoi(msg)
+	if err != nil || held == 0 {
+		return
+	}
+	note := fmt.Sprintf("(%d more lines held back by rank %d, which are only in the output of the job)", held, rank)
+	showSuppressed(rank, note, t)
+}
+
+func showSuppressed(rank int, note string, t *tui.TUI) {
+	transcriptOutput(rank, note)
+	t.ShowMessagesClient(note, rank)
+}
+
+// sendFlow pauses or resumes the output of a client. There is no client to
+// tell during a replay.
+func sendFlow(rank int, flow string) {
+	if c, ok := connections[rank]; ok && c != nil {
+		fmt.Fprintf(*c, "FLOW:%s\n", flow)
+	}
+}
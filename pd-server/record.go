@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"git.cse.iitk.ac.in/ssaha/parallel-debugger/utils"
+)
+
+var recordFile = flag.String("record", "", "File to which every session is recorded, to be played with pd-server replay")
+
+// recordEntry is a line of a recording, which holds one JSON object per
+// line.
+type recordEntry struct {
+	Time time.Time `json:"time"`
+	// Kind is "session" when all the clients of a session are connected,
+	// "command" for the input of the user and "client" for a message from
+	// a client.
+	Kind string `json:"kind"`
+	// Size is the world size of a session.
+	Size int `json:"size,omitempty"`
+	// Rank is the rank which sent a message, and Ranks the ranks a command
+	// was meant for, where nil means all of them.
+	Rank  int   `json:"rank,omitempty"`
+	Ranks []int `json:"ranks"`
+	// Category is the category of a message, e.g. CONSOLE or STATE.
+	Category string `json:"category,omitempty"`
+	Message  string `json:"message,omitempty"`
+}
+
+var recorder struct {
+	enc *json.Encoder
+	mux sync.Mutex
+}
+
+// openRecording starts recording to the file given with -record, if any.
+func openRecording() {
+	if *recordFile == "" {
+		return
+	}
+	f, err := os.OpenFile(*recordFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	utils.CheckError(err)
+	recorder.mux.Lock()
+	recorder.enc = json.NewEncoder(f)
+	recorder.mux.Unlock()
+}
+
+// record adds an entry to the recording. The file is not buffered, so that
+// the recording survives the server being killed.
+func record(e recordEntry) {
+	recorder.mux.Lock()
+	defer recorder.mux.Unlock()
+	if recorder.enc == nil {
+		return
+	}
+	e.Time = time.Now()
+	recorder.enc.Encode(e)
+}
+
+func recordSession(wSize int) {
+	record(recordEntry{Kind: "session", Size: wSize})
+}
+
+func recordCommand(input string, ranks []int) {
+	record(recordEntry{Kind: "command", Ranks: ranks, Message: input})
+}
+
+func recordClientMessage(rank int, cat string, msg string) {
+	record(recordEntry{Kind: "client", Rank: rank, Category: cat, Message: msg})
+}
+
+// loadRecording reads all the entries of a recording.
+func loadRecording(path string) ([]recordEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []recordEntry
+	dec := json.NewDecoder(f)
+	for {
+		var e recordEntry
+		err := dec.Decode(&e)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return entries, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
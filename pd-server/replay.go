@@ -0,0 +1,401 @@
+package main
+
+import (
+	"container/list"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"git.cse.iitk.ac.in/ssaha/parallel-debugger/pd-server/tui"
+	"git.cse.iitk.ac.in/ssaha/parallel-debugger/utils"
+	tuiGo "github.com/marcusolsson/tui-go"
+)
+
+// replayMain plays a recording made with -record, in the TUI or as plain
+// text, without any clients:
+//
+//	pd-server replay [options] <file>
+//
+// In the TUI, play, pause, seek and speed control the playback.
+func replayMain(args []string) {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	headless := flags.Bool("headless", false, "Print the recording instead of showing it in the TUI")
+	realtime := flags.Bool("realtime", false, "With -headless, print the entries at the pace they were recorded")
+	speed := flags.Float64("speed", 1, "Playback speed, e.g. 2 plays twice as fast")
+	from := flags.Duration("from", 0, "With -headless, print from this far into the recording, e.g. 1m30s")
+	to := flags.Duration("to", 0, "With -headless, print up to this far into the recording (default: the end)")
+	flags.Usage = func() {
+		fmt.Printf("Usage %s replay [options] <file>\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 || *speed <= 0 {
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	entries, err := loadRecording(flags.Arg(0))
+	if err != nil {
+		if len(entries) == 0 {
+			log.Fatalln(err)
+		}
+		// The server may have been killed halfway through an entry.
+		log.Printf("Ignoring the rest of the recording: %s\n", err.Error())
+	}
+	if len(entries) == 0 {
+		log.Fatalln("The recording is empty")
+	}
+
+	// There are no clients to stop.
+	*stopOnCrash = false
+
+	if *headless {
+		replayHeadless(entries, *from, *to, *realtime, *speed)
+		return
+	}
+
+	p := &player{
+		entries: entries,
+		speed:   *speed,
+		playing: true,
+		wake:    make(chan bool, 1),
+	}
+	// A recording holds all the output the ranks sent, which is played
+	// faster than it was printed when seeking.
+	*outputRate = 0
+	resetOutput()
+	resetSession()
+	nameSession("replay of " + filepath.Base(flags.Arg(0)))
+	if entries[0].Kind == "session" {
+		startReplayedSession(entries[0].Size, nil)
+	}
+	p.t = tui.NewTUI(connections)
+	limitHistory(p.t)
+	p.t.DrawUI()
+	go watchStatus(p.t, nil)
+	p.t.OnFocus(func(rank int) { go focusSource(rank, p.t) })
+	p.t.OnComplete(completeInput)
+	p.t.Input.OnSubmit(func(e *tuiGo.Entry) {
+		replayInput(e.Text(), p)
+		p.t.AddToCmdHistory(e.Text())
+		p.t.Input.SetText("")
+	})
+	p.run()
+}
+
+// player plays the entries of a recording in the TUI.
+type player struct {
+	entries []recordEntry
+	t       *tui.TUI
+	// next is the index of the next entry to be played.
+	next    int
+	playing bool
+	speed   float64
+	// gen changes every time the playback is paused, resumed or moved.
+	gen  int
+	wake chan bool
+	mux  sync.Mutex
+}
+
+// offset tells how far into the recording the i-th entry is.
+func (p *player) offset(i int) time.Duration {
+	return p.entries[i].Time.Sub(p.entries[0].Time)
+}
+
+func (p *player) length() time.Duration {
+	return p.offset(len(p.entries) - 1)
+}
+
+// position tells how far into the recording the playback is.
+// p.mux must be held by the caller.
+func (p *player) position() time.Duration {
+	if p.next == 0 {
+		return 0
+	}
+	return p.offset(p.next - 1)
+}
+
+// changed wakes the playing goroutine up after the playback was changed.
+// p.mux must be held by the caller.
+func (p *player) changed() {
+	p.gen++
+	select {
+	case p.wake <- true:
+	default:
+	}
+}
+
+// run plays the entries at the pace they were recorded, for as long as the
+// playback is not paused.
+func (p *player) run() {
+	for {
+		p.mux.Lock()
+		if p.playing && p.next == len(p.entries) {
+			p.playing = false
+			go p.showPosition("Replay finished")
+		}
+		if !p.playing {
+			p.mux.Unlock()
+			<-p.wake
+			continue
+		}
+		gen := p.gen
+		wait := time.Duration(float64(p.offset(p.next)-p.position()) / p.speed)
+		p.mux.Unlock()
+
+		select {
+		case <-time.After(wait):
+		case <-p.wake:
+			continue
+		}
+
+		p.mux.Lock()
+		if gen == p.gen {
+			applyEntry(p.entries[p.next], p.t)
+			p.next++
+		}
+		p.mux.Unlock()
+	}
+}
+
+func (p *player) play() {
+	p.mux.Lock()
+	if p.next == len(p.entries) {
+		p.seekTo(0)
+	}
+	p.playing = true
+	p.changed()
+	p.mux.Unlock()
+}
+
+func (p *player) pause() {
+	p.mux.Lock()
+	p.playing = false
+	p.changed()
+	p.mux.Unlock()
+	p.showPosition("Replay paused")
+}
+
+func (p *player) setSpeed(speed float64) {
+	p.mux.Lock()
+	p.speed = speed
+	p.changed()
+	p.mux.Unlock()
+}
+
+// seek moves the playback to pos, or by pos if relative is set.
+func (p *player) seek(pos time.Duration, relative bool) {
+	p.mux.Lock()
+	if relative {
+		pos += p.position()
+	}
+	if pos < 0 {
+		pos = 0
+	}
+	p.seekTo(pos)
+	p.changed()
+	p.mux.Unlock()
+	p.showPosition("Replay moved")
+}
+
+// seekTo plays everything up to pos again from the start, without waiting.
+// p.mux must be held by the caller.
+func (p *player) seekTo(pos time.Duration) {
+	p.t.Clear()
+	p.next = 0
+	for p.next < len(p.entries) && p.offset(p.next) <= pos {
+		applyEntry(p.entries[p.next], p.t)
+		p.next++
+	}
+}
+
+func (p *player) showPosition(what string) {
+	p.mux.Lock()
+	s := fmt.Sprintf("%s at %s of %s", what, p.position().Round(time.Millisecond), p.length().Round(time.Millisecond))
+	p.mux.Unlock()
+	p.t.ShowMessagesAll(s)
+}
+
+// replayInput takes the input of the user during a replay. Input handlers
+// run on the UI goroutine, which the player needs to show anything, hence
+// the goroutines.
+func replayInput(input string, p *player) {
+	fields := strings.Fields(input)
+	if len(fields) == 0 {
+		return
+	}
+	switch fields[0] {
+	case "play":
+		go p.play()
+		return
+	case "pause":
+		go p.pause()
+		return
+	case "seek":
+		if len(fields) != 2 {
+			break
+		}
+		relative := strings.HasPrefix(fields[1], "+") || strings.HasPrefix(fields[1], "-")
+		pos, err := time.ParseDuration(fields[1])
+		if err != nil {
+			break
+		}
+		go p.seek(pos, relative)
+		return
+	case "speed":
+		if len(fields) != 2 {
+			break
+		}
+		speed, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil || speed <= 0 {
+			break
+		}
+		go p.setSpeed(speed)
+		return
+	}
+	if takeLocalInput(input, p.t) || takeSourceInput(input, p.t) {
+		return
+	}
+	go p.t.ShowMessagesAll("Replaying a recording, use play, pause, seek <[+-]duration> or speed <x>")
+}
+
+// startReplayedSession sets the server up as if the wSize clients of a
+// session had just connected. The panes of t, if any, read the ranks on the
+// UI goroutine, so they are changed there.
+func startReplayedSession(wSize int, t *tui.TUI) {
+	setRanks := func() {
+		for rank := range connections {
+			delete(connections, rank)
+		}
+		for rank := 0; rank < wSize; rank++ {
+			connections[rank] = nil
+		}
+	}
+	if t != nil {
+		t.Update(setRanks)
+	} else {
+		setRanks()
+	}
+	resetRankStates()
+	resetTranscript()
+	resetRankVars()
+	resetSources()
+	resetCompletions()
+	resetOutput()
+	resetSession()
+	collectiveCallList.mux.Lock()
+	collectiveCallList.calls = list.New()
+	collectiveCallList.mux.Unlock()
+}
+
+// applyEntry shows an entry of a recording as it was shown when it was
+// recorded. Commands which were sent to the clients are shown as they were
+// typed, while those handled by the server alone are run again if they
+// show anything.
+func applyEntry(e recordEntry, t *tui.TUI) {
+	switch e.Kind {
+	case "session":
+		startReplayedSession(e.Size, t)
+		t.Clear()
+	case "command":
+		switch {
+		case e.Message == "pdb_listcoll":
+			prettyPrintCollectiveInfo(pendingCollectiveInfo(), t)
+		case e.Message == "pdb_status":
+			prettyPrintStatus(t)
+		case e.Message == "pdb_summary":
+			prettyPrintSummary(t)
+		case e.Message == "pdb_events":
+			t.ShowEvents()
+		case takeSourceInput(e.Message, t):
+		case strings.HasPrefix(e.Message, "pdb_trackcoll"):
+			transcriptCommand(e.Message, nil, e.Time)
+		case e.Message == "quit", e.Message == "pdb_detach", strings.HasPrefix(e.Message, "pdb_show"), firstWord(e.Message) == "swap",
+			firstWord(e.Message) == "add", firstWord(e.Message) == "remove",
+			strings.HasPrefix(e.Message, "/"), strings.HasPrefix(e.Message, "pdb_filter"),
+			strings.HasPrefix(e.Message, "pdb_layout"), strings.HasPrefix(e.Message, "pdb_diff"):
+			// How the panes were laid out and searched is up to whoever is
+			// watching.
+		default:
+			transcriptCommand(e.Message, e.Ranks, e.Time)
+			command, _ := parseInput(e.Message)
+			t.Update(func() {
+				t.ShowUserInputClients(command, e.Ranks)
+			})
+		}
+	case "client":
+		handleClientMessage(e.Category, e.Message, e.Rank, t)
+	}
+}
+
+// replayHeadless prints the entries of a recording which are between from
+// and to, one per line.
+func replayHeadless(entries []recordEntry, from time.Duration, to time.Duration, realtime bool, speed float64) {
+	last := from
+	for _, e := range entries {
+		offset := e.Time.Sub(entries[0].Time)
+		if to != 0 && offset > to {
+			break
+		}
+		show := offset >= from
+		if show && realtime {
+			time.Sleep(time.Duration(float64(offset-last) / speed))
+			last = offset
+		}
+
+		// The states of the ranks are tracked even before from, so that
+		// crashes and the summary are told as they were.
+		var lines []string
+		switch e.Kind {
+		case "session":
+			startReplayedSession(e.Size, nil)
+			lines = append(lines, fmt.Sprintf("Session with %d ranks", e.Size))
+		case "command":
+			lines = append(lines, fmt.Sprintf("> %s", e.Message))
+		case "client":
+			lines = headlessClientMessage(e.Category, e.Message, e.Rank)
+		}
+		if !show {
+			continue
+		}
+		for _, line := range lines {
+			fmt.Printf("%10s %s\n", offset.Round(time.Millisecond), line)
+		}
+	}
+}
+
+// headlessClientMessage gives the lines which tell about a message from a
+// client.
+func headlessClientMessage(cat string, msg string, rank int) (lines []string) {
+	switch cat {
+	case "CONSOLE":
+		lines = append(lines, fmt.Sprintf("[rank %d] %s", rank, msg))
+	case "ERROR":
+		lines = append(lines, fmt.Sprintf("[rank %d] (!) %s", rank, msg))
+	case "COLLECTIVE":
+		var coll utils.CollectiveInfo
+		_ = json.Unmarshal([]byte(msg), &coll)
+		trackCollective(coll)
+		lines = append(lines, fmt.Sprintf("[rank %d] called %s at %s", rank, coll.FunctionName, coll.LineInfo))
+	case "STATE":
+		var state utils.StateInfo
+		_ = json.Unmarshal([]byte(msg), &state)
+		newCrash, showSummary := recordRankState(state)
+		lines = append(lines, fmt.Sprintf("[rank %d] %s", rank, stateSummary(state)))
+		if newCrash {
+			lines = append(lines, fmt.Sprintf("Rank %d crashed: %s", rank, stateSummary(state)))
+		}
+		if showSummary {
+			lines = append(lines, strings.Split(strings.TrimSpace(sessionSummary()), "\n")...)
+		}
+	}
+	return
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"git.cse.iitk.ac.in/ssaha/parallel-debugger/utils"
+)
+
+// requestTimeout is how long to wait for the clients to answer a request.
+// A client answers only once it is done with what it was doing before, so
+// a running rank answers once it stops.
+const requestTimeout = 5 * time.Second
+
+// pendingRequest is a request which some of the ranks did not answer yet.
+type pendingRequest struct {
+	op     string
+	handle func(rank int, resp utils.Response)
+	// left holds the ranks which did not answer, and handled counts the
+	// responses which were handled, along with the ranks which were
+	// dropped since they are gone.
+	left    map[int]bool
+	dropped []int
+	handled int
+	total   int
+	done    chan bool
+}
+
+var requests struct {
+	nextID  int
+	pending map[int]*pendingRequest
+	mux     sync.Mutex
+}
+
+func init() {
+	requests.pending = make(map[int]*pendingRequest)
+}
+
+// resetRequests forgets the requests of a finished session.
+func resetRequests() {
+	requests.mux.Lock()
+	requests.pending = make(map[int]*pendingRequest)
+	requests.mux.Unlock()
+}
+
+// request sends the request op to every rank in args, with the arguments
+// for that rank, and calls handle with the response of every rank as it
+// arrives. It waits for all of them to answer, for at most requestTimeout,
+// and gives back the ranks which did not, including those which are gone.
+// The responses of the others are still handled whenever they arrive.
+func request(op string, args map[int][]string, handle func(rank int, resp utils.Response)) (missing []int) {
+	return requestWithin(requestTimeout, op, args, handle)
+}
+
+// requestWithin is request with another timeout, for requests which resume
+// the ranks.
+func requestWithin(timeout time.Duration, op string, args map[int][]string, handle func(rank int, resp utils.Response)) (missing []int) {
+	p := &pendingRequest{
+		op:     op,
+		handle: handle,
+		left:   make(map[int]bool),
+		done:   make(chan bool),
+	}
+
+	requests.mux.Lock()
+	requests.nextID++
+	id := requests.nextID
+	var ranks []int
+	for rank := range args {
+		if c, ok := connections[rank]; ok && c != nil {
+			p.left[rank] = true
+			ranks = append(ranks, rank)
+		}
+	}
+	p.total = len(p.left)
+	if p.total == 0 {
+		requests.mux.Unlock()
+		return nil
+	}
+	requests.pending[id] = p
+	requests.mux.Unlock()
+
+	for _, rank := range ranks {
+		out, _ := json.Marshal(utils.Request{ID: id, Op: op, Args: args[rank]})
+		sendMsgTo(string(out), []int{rank}, "REQUEST")
+	}
+
+	select {
+	case <-p.done:
+	case <-time.After(timeout):
+	}
+
+	requests.mux.Lock()
+	for rank := range p.left {
+		missing = append(missing, rank)
+	}
+	missing = append(missing, p.dropped...)
+	requests.mux.Unlock()
+	sort.Ints(missing)
+	return
+}
+
+// requestAll is request with the same arguments for every rank.
+func requestAll(op string, ranks []int, args []string, handle func(rank int, resp utils.Response)) (missing []int) {
+	rankArgs := make(map[int][]string)
+	for _, rank := range ranks {
+		rankArgs[rank] = args
+	}
+	return request(op, rankArgs, handle)
+}
+
+// handleResponse passes the response of a rank to whoever made the request.
+func handleResponse(rank int, msg string) {
+	var resp utils.Response
+	if err := json.Unmarshal([]byte(msg), &resp); err != nil {
+		return
+	}
+
+	requests.mux.Lock()
+	p, ok := requests.pending[resp.ID]
+	if !ok || !p.left[rank] {
+		requests.mux.Unlock()
+		return
+	}
+	delete(p.left, rank)
+	if len(p.left) == 0 {
+		delete(requests.pending, resp.ID)
+	}
+	requests.mux.Unlock()
+
+	p.handle(rank, resp)
+
+	requests.mux.Lock()
+	p.handled++
+	if p.handled == p.total {
+		close(p.done)
+	}
+	requests.mux.Unlock()
+}
+
+// dropRequests stops waiting for a rank which is gone to answer, which
+// is counted as missing, and forgets the requests which only waited for it.
+func dropRequests(rank int) {
+	requests.mux.Lock()
+	defer requests.mux.Unlock()
+	for id, p := range requests.pending {
+		if !p.left[rank] {
+			continue
+		}
+		delete(p.left, rank)
+		p.dropped = append(p.dropped, rank)
+		if len(p.left) == 0 {
+			delete(requests.pending, id)
+		}
+		p.handled++
+		if p.handled == p.total {
+			close(p.done)
+		}
+	}
+}
+
+// awaitingAnswer tells whether a rank did not answer a request yet.
+func awaitingAnswer(rank int) bool {
+	requests.mux.Lock()
+	defer requests.mux.Unlock()
+	for _, p := range requests.pending {
+		if p.left[rank] {
+			return true
+		}
+	}
+	return false
+}
+
+// requestsInFlight gives the ranks which did not answer yet, by the
+// operation they were asked for.
+func requestsInFlight() map[string][]int {
+	inFlight := make(map[string][]int)
+	requests.mux.Lock()
+	defer requests.mux.Unlock()
+	for _, p := range requests.pending {
+		for rank := range p.left {
+			inFlight[p.op] = append(inFlight[p.op], rank)
+		}
+	}
+	return inFlight
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"git.cse.iitk.ac.in/ssaha/parallel-debugger/pd-server/tui"
+)
+
+// searchPanes handles /pattern, which finds the messages of every rank
+// which match the regular expression pattern, highlights them and jumps to
+// the first of them. A / alone ends the search, and so does a pattern which matches
+// nothing.
+func searchPanes(pattern string, t *tui.TUI) {
+	if pattern == "" {
+		t.Search(nil)
+		return
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		go t.ShowMessagesAll(fmt.Sprintf("Bad pattern /%s: %s", pattern, err))
+		return
+	}
+	if t.Search(re) == 0 {
+		t.Search(nil)
+		go t.ShowMessagesAll(fmt.Sprintf("Nothing the ranks printed matches /%s", pattern))
+	}
+}
+
+// filterPanes handles pdb_filter, which shows only the lines of the panes
+// which match a regular expression, or with -v only those which do not:
+//
+//	pdb_filter [-v] <regexp>
+//	pdb_filter off
+func filterPanes(args []string, t *tui.TUI) {
+	if len(args) == 1 && args[0] == "off" {
+		t.Filter(nil, false)
+		return
+	}
+	out := len(args) != 0 && args[0] == "-v"
+	if out {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		go t.ShowMessagesAll("Usage: pdb_filter [-v] <regexp> to show only the lines which match, or with -v do not, and pdb_filter off to show all of them")
+		return
+	}
+	re, err := regexp.Compile(strings.Join(args, " "))
+	if err != nil {
+		go t.ShowMessagesAll(fmt.Sprintf("Bad pattern %s: %s", strings.Join(args, " "), err))
+		return
+	}
+	t.Filter(re, out)
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"git.cse.iitk.ac.in/ssaha/parallel-debugger/pd-server/tui"
+	"git.cse.iitk.ac.in/ssaha/parallel-debugger/utils"
+)
+
+// sourceContext is how many lines are shown around the line in the middle
+// of the source pane.
+const sourceContext = 7
+
+// sourceFile is a source file as read by a client, or why it could not be.
+type sourceFile struct {
+	lines []string
+	err   string
+}
+
+// sourceView tells what the source pane shows. The sources are fetched from
+// the clients, since the server may not have them.
+var sourceView struct {
+	shown bool
+	// follow is the rank whose location is shown, or -1 to show where most
+	// of the ranks are. The rank whose pane has the focus, if any, is
+	// followed instead.
+	follow   int
+	focused  int
+	files    map[string]*sourceFile
+	fetching map[string]bool
+	mux      sync.Mutex
+}
+
+func init() {
+	sourceView.follow = -1
+	sourceView.focused = -1
+	sourceView.files = make(map[string]*sourceFile)
+	sourceView.fetching = make(map[string]bool)
+}
+
+// resetSources forgets the sources fetched in a finished session, which
+// may have changed since.
+func resetSources() {
+	sourceView.mux.Lock()
+	sourceView.files = make(map[string]*sourceFile)
+	sourceView.fetching = make(map[string]bool)
+	sourceView.mux.Unlock()
+}
+
+// takeSourceInput shows or hides the source pane, and tells if input was
+// the command for it:
+//
+//	pdb_source          show where most of the ranks are
+//	pdb_source <rank>   show where a rank is
+//	pdb_source off      hide the pane
+func takeSourceInput(input string, t *tui.TUI) bool {
+	fields := strings.Fields(input)
+	if len(fields) == 0 || fields[0] != "pdb_source" {
+		return false
+	}
+	if len(fields) > 2 {
+		go t.ShowMessagesAll("Usage: pdb_source [rank|off]")
+		return true
+	}
+
+	follow := -1
+	if len(fields) == 2 {
+		if fields[1] == "off" {
+			sourceView.mux.Lock()
+			sourceView.shown = false
+			sourceView.mux.Unlock()
+			go t.HideSource()
+			return true
+		}
+		rank, err := strconv.Atoi(fields[1])
+		if _, ok := connections[rank]; err != nil || !ok {
+			go t.ShowMessagesAll(fmt.Sprintf("No rank %s", fields[1]))
+			return true
+		}
+		follow = rank
+	}
+	followSource(follow, t)
+	return true
+}
+
+// followSource shows the source pane, following the location of a rank, or
+// where most of the ranks are if rank is -1.
+func followSource(rank int, t *tui.TUI) {
+	sourceView.mux.Lock()
+	sourceView.shown = true
+	sourceView.follow = rank
+	sourceView.mux.Unlock()
+	go refreshSource(t)
+}
+
+// focusSource follows the location of the rank whose pane has the focus,
+// or what pdb_source chose again if rank is -1.
+func focusSource(rank int, t *tui.TUI) {
+	sourceView.mux.Lock()
+	sourceView.focused = rank
+	sourceView.mux.Unlock()
+	refreshSource(t)
+}
+
+// sourceLocation is a line of a source file where ranks are stopped.
+type sourceLocation struct {
+	file     string
+	fullname string
+	line     int
+	// from is a rank stopped in the file, which can read it.
+	from int
+}
+
+// refreshSource shows the lines around the location followed by the
+// source pane, if it is shown, marking where the ranks are stopped and
+// where the breakpoints are.
+func refreshSource(t *tui.TUI) {
+	sourceView.mux.Lock()
+	shown, follow := sourceView.shown, sourceView.follow
+	if sourceView.focused >= 0 {
+		follow = sourceView.focused
+	}
+	sourceView.mux.Unlock()
+	if !shown {
+		return
+	}
+
+	following := "where most ranks are"
+	if follow >= 0 {
+		following = fmt.Sprintf("rank %d", follow)
+	}
+	loc, ok := followedLocation(follow)
+	if !ok {
+		t.ShowSource(fmt.Sprintf("source (%s)", following), []string{"Not stopped at a line with debug information"})
+		return
+	}
+	title := fmt.Sprintf("source %s (%s)", loc.file, following)
+
+	sourceView.mux.Lock()
+	file, fetched := sourceView.files[loc.fullname]
+	fetching := sourceView.fetching[loc.fullname]
+	if !fetched && !fetching {
+		sourceView.fetching[loc.fullname] = true
+	}
+	sourceView.mux.Unlock()
+	switch {
+	case !fetched:
+		if !fetching {
+			go fetchSource(loc, t)
+		}
+		t.ShowSource(title, []string{fmt.Sprintf("Fetching %s from rank %d", loc.fullname, loc.from)})
+	case file.err != "":
+		t.ShowSource(title, []string{fmt.Sprintf("Could not read %s: %s", loc.fullname, file.err)})
+	default:
+		t.ShowSource(title, sourceText(file.lines, loc))
+	}
+}
+
+// followedLocation gives the location of a rank, or the line where most of
+// the ranks are stopped if rank is -1.
+func followedLocation(rank int) (sourceLocation, bool) {
+	rankStates.mux.Lock()
+	defer rankStates.mux.Unlock()
+
+	stoppedAt := func(rank int) (sourceLocation, bool) {
+		info, ok := rankStates.states[rank]
+		if !ok || info.State != "stopped" || info.Fullname == "" {
+			return sourceLocation{}, false
+		}
+		line, err := strconv.Atoi(info.Line)
+		if err != nil {
+			return sourceLocation{}, false
+		}
+		return sourceLocation{info.File, info.Fullname, line, rank}, true
+	}
+	if rank >= 0 {
+		return stoppedAt(rank)
+	}
+
+	// Ranks are taken in order, so of the lines with as many ranks, the
+	// one with the lowest rank wins.
+	groups := make(map[string][]sourceLocation)
+	var best []sourceLocation
+	for _, rank := range allRanks() {
+		at, ok := stoppedAt(rank)
+		if !ok {
+			continue
+		}
+		key := fmt.Sprintf("%s:%d", at.fullname, at.line)
+		groups[key] = append(groups[key], at)
+		if len(groups[key]) > len(best) {
+			best = groups[key]
+		}
+	}
+	if len(best) == 0 {
+		return sourceLocation{}, false
+	}
+	return best[0], true
+}
+
+// fetchSource asks the rank in loc for the source file it is stopped in.
+// If it does not answer in time, it may be running, and the file is asked
+// for again on the next refresh.
+func fetchSource(loc sourceLocation, t *tui.TUI) {
+	if connections[loc.from] == nil {
+		// There are no clients when a recording is replayed, but the
+		// sources may be right here.
+		file := &sourceFile{}
+		var err error
+		if file.lines, err = utils.ReadSource(loc.fullname); err != nil {
+			file.err = err.Error()
+		}
+		sourceView.mux.Lock()
+		sourceView.files[loc.fullname] = file
+		delete(sourceView.fetching, loc.fullname)
+		sourceView.mux.Unlock()
+		refreshSource(t)
+		return
+	}
+
+	missing := requestAll("source", []int{loc.from}, []string{loc.fullname}, func(rank int, resp utils.Response) {
+		file := &sourceFile{err: resp.Error}
+		if resp.Error == "" {
+			if err := json.Unmarshal(resp.Result, &file.lines); err != nil {
+				file.err = err.Error()
+			}
+		}
+		sourceView.mux.Lock()
+		sourceView.files[loc.fullname] = file
+		delete(sourceView.fetching, loc.fullname)
+		sourceView.mux.Unlock()
+		go refreshSource(t)
+	})
+	if len(missing) != 0 {
+		sourceView.mux.Lock()
+		delete(sourceView.fetching, loc.fullname)
+		sourceView.mux.Unlock()
+	}
+}
+
+// sourceText gives the lines around a location, with markers for the
+// ranks stopped on them and the breakpoints set on them, e.g.
+//
+//	▶ [0-3]    42  MPI_Barrier(MPI_COMM_WORLD);
+//
+// The ranks stopped elsewhere in the file are listed after them.
+func sourceText(lines []string, loc sourceLocation) []string {
+	ranks := make(map[int][]int)
+	rankStates.mux.Lock()
+	for rank, info := range rankStates.states {
+		if info.State == "stopped" && info.Fullname == loc.fullname {
+			line, _ := strconv.Atoi(info.Line)
+			ranks[line] = append(ranks[line], rank)
+		}
+	}
+	rankStates.mux.Unlock()
+
+	bkpts := make(map[int][]int)
+	breakpoints.mux.Lock()
+	for id, b := range breakpoints.byID {
+		for _, bp := range b.installs {
+			line, _ := strconv.Atoi(bp.Line)
+			if filepath.Base(bp.File) == filepath.Base(loc.file) && !containsRank(bkpts[line], id) {
+				bkpts[line] = append(bkpts[line], id)
+			}
+		}
+	}
+	breakpoints.mux.Unlock()
+
+	gutter := func(line int) string {
+		var marks []string
+		if r, ok := ranks[line]; ok {
+			marks = append(marks, "▶ "+formatRanks(r))
+		}
+		ids := bkpts[line]
+		sort.Ints(ids)
+		for _, id := range ids {
+			marks = append(marks, fmt.Sprintf("●%d", id))
+		}
+		return strings.Join(marks, " ")
+	}
+
+	first, last := loc.line-sourceContext, loc.line+sourceContext
+	if first < 1 {
+		first = 1
+	}
+	if last > len(lines) {
+		last = len(lines)
+	}
+	width := 0
+	for n := first; n <= last; n++ {
+		if w := len([]rune(gutter(n))); w > width {
+			width = w
+		}
+	}
+
+	var text []string
+	for n := first; n <= last; n++ {
+		mark := gutter(n)
+		mark += strings.Repeat(" ", width-len([]rune(mark)))
+		text = append(text, fmt.Sprintf("%s %5d  %s", mark, n, strings.Replace(lines[n-1], "\t", "    ", -1)))
+	}
+
+	var elsewhere []int
+	for line := range ranks {
+		if line < first || line > last {
+			elsewhere = append(elsewhere, line)
+		}
+	}
+	sort.Ints(elsewhere)
+	for _, line := range elsewhere {
+		text = append(text, fmt.Sprintf("▶ %s at line %d", formatRanks(ranks[line]), line))
+	}
+	return text
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"git.cse.iitk.ac.in/ssaha/parallel-debugger/pd-server/tui"
+	"git.cse.iitk.ac.in/ssaha/parallel-debugger/utils"
+)
+
+// rankStates holds the last execution state reported by every rank, and
+// the state in which every crashed rank crashed.
+var rankStates struct {
+	states       map[int]*utils.StateInfo
+	crashes      map[int]*utils.StateInfo
+	summaryShown bool
+	mux          sync.Mutex
+}
+
+func init() {
+	rankStates.states = make(map[int]*utils.StateInfo)
+	rankStates.crashes = make(map[int]*utils.StateInfo)
+}
+
+// resetRankStates forgets the states of the ranks of a finished session.
+func resetRankStates() {
+	rankStates.mux.Lock()
+	rankStates.states = make(map[int]*utils.StateInfo)
+	rankStates.crashes = make(map[int]*utils.StateInfo)
+	rankStates.summaryShown = false
+	rankStates.mux.Unlock()
+}
+
+// updateRankState records the new state of a rank and reflects it in the
+// title of the rank's pane. When a rank crashes, every other rank is stopped
+// if -stop-on-crash is set. Once all the ranks are done, a summary of the
+// session is shown.
+func updateRankState(info utils.StateInfo, t *tui.TUI) {
+	newCrash, showSummary := recordRankState(info)
+
+	t.SetRankStatus(info.Rank, stateSummary(info))
+	t.SetRankState(info.Rank, overviewState(info))
+	logStateEvent(info, t)
+	if info.State != "running" {
+		barrierReached(info.Rank)
+	}
+
+	if newCrash {
+		t.ShowMessagesAll(fmt.Sprintf("Rank %d crashed: %s", info.Rank, stateSummary(info)))
+		if *stopOnCrash {
+			stopRunningRanks()
+		}
+	}
+	if showSummary {
+		prettyPrintSummary(t)
+	}
+	refreshSource(t)
+}
+
+// refreshRankState shows the last state of a rank again in the overview,
+// after something it depends on changed.
+func refreshRankState(rank int, t *tui.TUI) {
+	rankStates.mux.Lock()
+	info, ok := rankStates.states[rank]
+	rankStates.mux.Unlock()
+	if ok {
+		t.SetRankState(rank, overviewState(*info))
+	}
+}
+
+// overviewState gives the state a rank is shown in by the overview. A rank
+// which is running after calling a collective which not every rank called
+// yet is likely waiting for the others.
+func overviewState(info utils.StateInfo) string {
+	switch {
+	case info.Crashed():
+		return "crashed"
+	case info.State == "exited":
+		return "exited"
+	case info.State == "running" && inPendingCollective(info.Rank):
+		return "collective"
+	}
+	return info.State
+}
+
+// recordRankState records the new state of a rank. It tells if the rank
+// just crashed, and if the summary of the session is due.
+func recordRankState(info utils.StateInfo) (newCrash bool, showSummary bool) {
+	if info.State == "stopped" && info.BkptNo > 0 {
+		breakpointHit(info.Rank, info.BkptNo)
+	}
+
+	rankStates.mux.Lock()
+	rankStates.states[info.Rank] = &info
+	if info.Crashed() {
+		// A rank which stopped on a fatal signal reports it again when
+		// it exits, but only the first report has the backtrace.
+		if _, ok := rankStates.crashes[info.Rank]; !ok {
+			rankStates.crashes[info.Rank] = &info
+			newCrash = true
+		}
+	}
+	showSummary = !rankStates.summaryShown && allRanksDone()
+	if showSummary {
+		rankStates.summaryShown = true
+	}
+	rankStates.mux.Unlock()
+	return
+}
+
+// rankDone tells if a rank will not make any more progress.
+func rankDone(info *utils.StateInfo) bool {
+	return info.State == "exited" || info.Crashed()
+}
+
+// allRanksDone tells if every connected rank is done.
+// rankStates.mux must be held by the caller.
+func allRanksDone() bool {
+	for rank := range connections {
+		info, ok := rankStates.states[rank]
+		if !ok || !rankDone(info) {
+			return false
+		}
+	}
+	return true
+}
+
+// stopRunningRanks interrupts every rank which is still running.
+func stopRunningRanks() {
+	var ranks []int
+	rankStates.mux.Lock()
+	for rank, info := range rankStates.states {
+		if info.State == "running" {
+			ranks = append(ranks, rank)
+		}
+	}
+	rankStates.mux.Unlock()
+
+	if len(ranks) != 0 {
+		sendMsgTo("", ranks, "INTERRUPT")
+	}
+}
+
+// stateSummary gives a short description of a state, e.g.
+// "stopped foo.c:88 bkpt 2", "running" or "exited 1".
+func stateSummary(info utils.StateInfo) string {
+	switch info.State {
+	case "stopped":
+		s := "stopped"
+		if loc := stateLocation(info); loc != "" {
+			s += " " + loc
+		}
+		if info.Reason == "mpi-abort" {
+			s += " MPI_Abort"
+		} else if info.Signal != "" {
+			s += " " + info.Signal
+		} else if id, ok := globalBreakpointID(info.Rank, info.BkptNo); ok {
+			s += fmt.Sprintf(" bkpt %d", id)
+		} else if info.BkptNo > 0 {
+			s += fmt.Sprintf(" gdb bkpt %d", info.BkptNo)
+		}
+		return s
+	case "exited":
+		if info.Signal != "" {
+			return fmt.Sprintf("exited %s", info.Signal)
+		}
+		return fmt.Sprintf("exited %d", info.ExitCode)
+	}
+	return info.State
+}
+
+// stateLocation gives the location of a stopped rank as file:line, falling
+// back to the function name when there is no line information.
+func stateLocation(info utils.StateInfo) string {
+	if info.File != "" && info.Line != "" {
+		return fmt.Sprintf("%s:%s", info.File, info.Line)
+	}
+	return info.Function
+}
+
+// prettyPrintStatus shows the ranks grouped by their state and location.
+func prettyPrintStatus(t *tui.TUI) {
+	t.ShowMessagesAll(statusText("Rank status:", allRanks()))
+}
+
+// statusText groups ranks by their state and location, under a title.
+func statusText(title string, ranks []int) string {
+	groups := make(map[string][]int)
+
+	rankStates.mux.Lock()
+	for _, rank := range ranks {
+		summary := "unknown"
+		if info, ok := rankStates.states[rank]; ok {
+			summary = stateSummary(*info)
+			if info.Reason != "" && info.State == "stopped" {
+				summary += fmt.Sprintf(" (%s)", info.Reason)
+			}
+		}
+		groups[summary] = append(groups[summary], rank)
+	}
+	rankStates.mux.Unlock()
+
+	var summaries []string
+	for summary := range groups {
+		summaries = append(summaries, summary)
+	}
+	sort.Strings(summaries)
+
+	s := title + "\n"
+	for _, summary := range summaries {
+		s += fmt.Sprintf("%s: %s\n", formatRanks(groups[summary]), summary)
+	}
+	return s
+}
+
+// prettyPrintSummary shows the summary of the session.
+func prettyPrintSummary(t *tui.TUI) {
+	t.ShowMessagesAll(sessionSummary())
+}
+
+// sessionSummary tells how every rank ended, along with the last frames of
+// the ranks that crashed.
+func sessionSummary() string {
+	groups := make(map[string][]int)
+	var crashed []int
+
+	rankStates.mux.Lock()
+	for rank := range connections {
+		summary := "still running"
+		if info, ok := rankStates.states[rank]; ok && rankDone(info) {
+			summary = stateSummary(*info)
+		}
+		groups[summary] = append(groups[summary], rank)
+	}
+	for rank := range rankStates.crashes {
+		crashed = append(crashed, rank)
+	}
+	sort.Ints(crashed)
+
+	var summaries []string
+	for summary := range groups {
+		summaries = append(summaries, summary)
+	}
+	sort.Strings(summaries)
+
+	s := "Session summary:\n"
+	for _, summary := range summaries {
+		s += fmt.Sprintf("%s: %s\n", formatRanks(groups[summary]), summary)
+	}
+	if len(crashed) != 0 {
+		s += "Crashed ranks:\n"
+	}
+	for _, rank := range crashed {
+		info := rankStates.crashes[rank]
+		s += fmt.Sprintf("Rank %d: %s\n", rank, stateSummary(*info))
+		for i, frame := range info.Frames {
+			s += fmt.Sprintf("  #%d %s\n", i, frame)
+		}
+	}
+	rankStates.mux.Unlock()
+	return s
+}
+
+// formatRanks formats a list of ranks compactly, e.g. [0-3,5].
+func formatRanks(ranks []int) string {
+	sorted := append([]int(nil), ranks...)
+	sort.Ints(sorted)
+
+	var parts []string
+	for i := 0; i < len(sorted); {
+		j := i
+		for j+1 < len(sorted) && sorted[j+1] == sorted[j]+1 {
+			j++
+		}
+		if i == j {
+			parts = append(parts, fmt.Sprintf("%d", sorted[i]))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", sorted[i], sorted[j]))
+		}
+		i = j + 1
+	}
+	return "[" + strings.Join(parts, ",") + "]"
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"git.cse.iitk.ac.in/ssaha/parallel-debugger/pd-server/tui"
+	"git.cse.iitk.ac.in/ssaha/parallel-debugger/utils"
+)
+
+var stepTimeout = flag.Duration("step-timeout", 30*time.Second, "How long pdb_step, pdb_next and pdb_continue wait for the ranks to stop")
+
+// barrier holds the ranks which pdb_step, pdb_next or pdb_continue are
+// waiting for. No command is sent to the clients until they all stop.
+var barrier struct {
+	waiting map[int]bool
+	// resumed holds the ranks which were sent the command resuming them,
+	// whose errors mean they will not stop.
+	resumed map[int]bool
+	done    chan bool
+	mux     sync.Mutex
+}
+
+// takeStepInput runs the commands which resume ranks and wait for them to
+// stop again, and pdb_interrupt, and tells if input was one of them:
+//
+//	pdb_step [-p] [args] [r=...]
+//	pdb_next [-p] [args] [r=...]
+//	pdb_continue [args] [r=...]
+//	pdb_interrupt [r=...]
+//
+// With -p, the ranks which must make progress for a blocking MPI call to
+// return are advanced to their matching call, and finish it along with the
+// ranks which are stepped.
+func takeStepInput(input string, t *tui.TUI) bool {
+	if !strings.HasPrefix(input, "pdb_") {
+		return false
+	}
+	command, ranks := parseInput(input)
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return false
+	}
+
+	switch fields[0] {
+	case "step", "next":
+		advancePeers := len(fields) > 1 && fields[1] == "-p"
+		if advancePeers {
+			command = strings.Join(append(fields[:1], fields[2:]...), " ")
+		}
+		stepRanks(command, true, advancePeers, ranks, t)
+	case "continue":
+		stepRanks(command, false, false, ranks, t)
+	case "interrupt":
+		sendMsgTo("", ranks, "INTERRUPT")
+	default:
+		return false
+	}
+	return true
+}
+
+// stepRanks sends a command which resumes ranks, and shows where they are
+// once they have all stopped again. When stepping, the ranks are first
+// checked for blocking MPI calls.
+func stepRanks(command string, stepping bool, advancePeers bool, ranks []int, t *tui.TUI) {
+	if ranks == nil {
+		ranks = allRanks()
+	}
+
+	// Ranks which are done would never stop again.
+	var targets, skipped []int
+	rankStates.mux.Lock()
+	for _, rank := range ranks {
+		if _, ok := connections[rank]; !ok {
+			continue
+		}
+		if info, ok := rankStates.states[rank]; ok && rankDone(info) {
+			skipped = append(skipped, rank)
+		} else {
+			targets = append(targets, rank)
+		}
+	}
+	rankStates.mux.Unlock()
+	if len(targets) == 0 {
+		go t.ShowMessagesAll("None of the ranks can be resumed")
+		return
+	}
+
+	// Input is blocked right away, even though checking the ranks takes a
+	// while.
+	done := startBarrier(targets)
+	go func() {
+		var calls map[int]*utils.MPICall
+		var advanced []int
+		var notes []string
+		if stepping {
+			calls = blockingCallsOf(targets)
+			peers, warnings := peersOf(calls, targets)
+			if advancePeers && len(peers) != 0 {
+				advanced, notes = advance(peers)
+				addToBarrier(advanced)
+			} else {
+				notes = warnings
+				if len(peers) != 0 {
+					notes = append(notes, "Use -p to advance them to their matching calls")
+				}
+			}
+		}
+
+		barrierResumed(targets)
+		commands, err := sendCommandTo(command, targets)
+		if err != nil {
+			endBarrier()
+			t.ShowMessagesAll(err.Error())
+			return
+		}
+		barrierResumed(advanced)
+		finishes, _ := sendCommandTo("finish", advanced)
+		t.Update(func() {
+			showCommands(command, commands, targets, t)
+			if len(advanced) != 0 {
+				showCommands("finish", finishes, advanced, t)
+			}
+		})
+		if len(notes) != 0 {
+			t.ShowMessagesAll(strings.Join(notes, "\n"))
+		}
+
+		select {
+		case <-done:
+		case <-time.After(*stepTimeout):
+		}
+		pending := endBarrier()
+
+		s := statusText("Ranks now at:", append(append([]int(nil), targets...), advanced...))
+		if len(skipped) != 0 {
+			s += fmt.Sprintf("Not resumed, since they are done: %s\n", formatRanks(skipped))
+		}
+		for _, rank := range pending {
+			if call, ok := calls[rank]; ok {
+				s += fmt.Sprintf("Rank %d is %s\n", rank, call)
+				t.SetRankStatus(rank, call.String())
+			}
+		}
+		if len(pending) != 0 {
+			s += fmt.Sprintf("Still not stopped after %s: %s, use pdb_interrupt to stop them\n", *stepTimeout, formatRanks(pending))
+		}
+		t.ShowMessagesAll(s)
+	}()
+}
+
+// blockingCallsOf asks the stopped ranks among ranks for the blocking MPI
+// call they are about to make, if any.
+func blockingCallsOf(ranks []int) map[int]*utils.MPICall {
+	var stopped []int
+	rankStates.mux.Lock()
+	for _, rank := range ranks {
+		if info, ok := rankStates.states[rank]; ok && info.State == "stopped" {
+			stopped = append(stopped, rank)
+		}
+	}
+	rankStates.mux.Unlock()
+
+	calls := make(map[int]*utils.MPICall)
+	finished := false
+	var mux sync.Mutex
+	requestAll("blocking-call", stopped, nil, func(rank int, resp utils.Response) {
+		var call *utils.MPICall
+		if resp.Error != "" || json.Unmarshal(resp.Result, &call) != nil || call == nil {
+			return
+		}
+		mux.Lock()
+		if !finished {
+			calls[rank] = call
+		}
+		mux.Unlock()
+	})
+
+	mux.Lock()
+	defer mux.Unlock()
+	finished = true
+	return calls
+}
+
+// peersOf finds the ranks which are not resumed, but must make progress
+// for the blocking calls of the ranks which are. It gives back the calls
+// they may make for that, along with warnings about them.
+func peersOf(calls map[int]*utils.MPICall, resumed []int) (peers map[int][]string, warnings []string) {
+	peers = make(map[int][]string)
+	var others []int
+	for _, rank := range allRanks() {
+		if !containsRank(resumed, rank) {
+			others = append(others, rank)
+		}
+	}
+	need := func(peer int, function string) {
+		for _, matching := range utils.MatchingCalls(function) {
+			if !containsString(peers[peer], matching) {
+				peers[peer] = append(peers[peer], matching)
+			}
+		}
+	}
+
+	for _, rank := range resumed {
+		call, ok := calls[rank]
+		if !ok {
+			continue
+		}
+		switch {
+		case call.Collective:
+			if len(others) == 0 {
+				continue
+			}
+			for _, peer := range others {
+				need(peer, call.Function)
+			}
+			warnings = append(warnings, fmt.Sprintf("Rank %d is about to call %s, which %s must call too", rank, call.Function, formatRanks(others)))
+		case call.Peer == utils.AnySource:
+			if len(others) != 0 {
+				warnings = append(warnings, fmt.Sprintf("Rank %d is about to call %s for any rank, which may be one of %s", rank, call.Function, formatRanks(others)))
+			}
+		case call.Peer >= 0 && !containsRank(resumed, call.Peer):
+			if _, ok := connections[call.Peer]; !ok {
+				continue
+			}
+			need(call.Peer, call.Function)
+			warnings = append(warnings, fmt.Sprintf("Rank %d is about to call %s for rank %d, which is not resumed", rank, call.Function, call.Peer))
+		}
+	}
+	return
+}
+
+// advance resumes the peers until they make one of their matching calls,
+// and gives back those which did, along with notes about how it went.
+func advance(peers map[int][]string) (advanced []int, notes []string) {
+	args := make(map[int][]string)
+	rankStates.mux.Lock()
+	for peer, functions := range peers {
+		info, ok := rankStates.states[peer]
+		switch {
+		case ok && rankDone(info):
+			notes = append(notes, fmt.Sprintf("Rank %d cannot be advanced, since it is done", peer))
+		case ok && info.State == "running":
+			notes = append(notes, fmt.Sprintf("Rank %d is running, it may get to its matching call by itself", peer))
+		default:
+			args[peer] = functions
+		}
+	}
+	rankStates.mux.Unlock()
+
+	finished := false
+	var mux sync.Mutex
+	missing := request("advance-to", args, func(rank int, resp utils.Response) {
+		mux.Lock()
+		defer mux.Unlock()
+		if finished {
+			return
+		}
+		var frame utils.Frame
+		if resp.Error != "" || json.Unmarshal(resp.Result, &frame) != nil {
+			notes = append(notes, fmt.Sprintf("Could not advance rank %d: %s", rank, resp.Error))
+			return
+		}
+		function := strings.TrimPrefix(frame.Function, "P")
+		if !containsString(args[rank], function) {
+			notes = append(notes, fmt.Sprintf("Rank %d stopped in %s before getting to its matching call", rank, frame.Function))
+			return
+		}
+		advanced = append(advanced, rank)
+	})
+
+	mux.Lock()
+	defer mux.Unlock()
+	finished = true
+	sort.Ints(advanced)
+	if len(advanced) != 0 {
+		notes = append(notes, fmt.Sprintf("Advanced %s to their matching calls", formatRanks(advanced)))
+	}
+	for _, rank := range missing {
+		notes = append(notes, fmt.Sprintf("Rank %d did not get to its matching call yet", rank))
+	}
+	return
+}
+
+// startBarrier starts waiting for ranks to stop. The channel it gives back
+// is closed once they all have.
+func startBarrier(ranks []int) chan bool {
+	barrier.mux.Lock()
+	defer barrier.mux.Unlock()
+	barrier.waiting = make(map[int]bool)
+	barrier.resumed = make(map[int]bool)
+	for _, rank := range ranks {
+		barrier.waiting[rank] = true
+	}
+	barrier.done = make(chan bool)
+	return barrier.done
+}
+
+// addToBarrier waits for more ranks to stop.
+func addToBarrier(ranks []int) {
+	barrier.mux.Lock()
+	defer barrier.mux.Unlock()
+	for _, rank := range ranks {
+		barrier.waiting[rank] = true
+	}
+}
+
+// endBarrier stops waiting, and gives back the ranks which did not stop.
+func endBarrier() (pending []int) {
+	barrier.mux.Lock()
+	defer barrier.mux.Unlock()
+	for rank := range barrier.waiting {
+		pending = append(pending, rank)
+	}
+	sort.Ints(pending)
+	barrier.waiting = nil
+	barrier.resumed = nil
+	barrier.done = nil
+	return
+}
+
+// barrierResumed tells the barrier that ranks are about to be sent the
+// command which resumes them.
+func barrierResumed(ranks []int) {
+	barrier.mux.Lock()
+	defer barrier.mux.Unlock()
+	for _, rank := range ranks {
+		barrier.resumed[rank] = true
+	}
+}
+
+// barrierReached tells the barrier that a rank stopped or exited.
+func barrierReached(rank int) {
+	barrier.mux.Lock()
+	defer barrier.mux.Unlock()
+	reached(rank)
+}
+
+// barrierFailed tells the barrier that a command failed on a rank. Only
+// the command which resumes the rank means it will not stop, while the
+// errors of the requests made before it, e.g. for its blocking call, do
+// not. A client handles one message at a time, so an error which arrives
+// while a request is in flight is that of the request.
+func barrierFailed(rank int) {
+	if awaitingAnswer(rank) {
+		return
+	}
+	barrier.mux.Lock()
+	defer barrier.mux.Unlock()
+	if barrier.resumed[rank] {
+		reached(rank)
+	}
+}
+
+// reached stops waiting for a rank. barrier.mux must be held by the caller.
+func reached(rank int) {
+	if !barrier.waiting[rank] {
+		return
+	}
+	delete(barrier.waiting, rank)
+	if len(barrier.waiting) == 0 {
+		close(barrier.done)
+	}
+}
+
+// barrierPending gives the ranks which are being waited for, if any.
+func barrierPending() (pending []int) {
+	barrier.mux.Lock()
+	defer barrier.mux.Unlock()
+	for rank := range barrier.waiting {
+		pending = append(pending, rank)
+	}
+	sort.Ints(pending)
+	return
+}
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"git.cse.iitk.ac.in/ssaha/parallel-debugger/pd-server/tui"
+)
+
+// Commands sent to the clients are templates, which are expanded for every
+// rank before being sent:
+//
+//	$rank, $size   the rank, and the number of ranks
+//	$name          a variable set for the rank with pdb_setvar
+//	${expr}        the value of expr, e.g. ${rank==0 ? 2 : 0}
+//
+// Expressions work on integers, with the operators of C except for the
+// bitwise and assignment ones. Any other $name, e.g. $pc, is left to gdb.
+
+// rankVars holds the variables set for every rank with pdb_setvar.
+var rankVars struct {
+	vars map[int]map[string]string
+	mux  sync.Mutex
+}
+
+func init() {
+	rankVars.vars = make(map[int]map[string]string)
+}
+
+// resetRankVars forgets the variables of a finished session.
+func resetRankVars() {
+	rankVars.mux.Lock()
+	rankVars.vars = make(map[int]map[string]string)
+	rankVars.mux.Unlock()
+}
+
+// rankVar gives the value of a variable for a rank.
+func rankVar(rank int, name string) (string, bool) {
+	switch name {
+	case "rank":
+		return strconv.Itoa(rank), true
+	case "size":
+		return strconv.Itoa(len(connections)), true
+	}
+	rankVars.mux.Lock()
+	defer rankVars.mux.Unlock()
+	value, ok := rankVars.vars[rank][name]
+	return value, ok
+}
+
+// takeVarInput runs pdb_setvar and pdb_vars, and tells if input was one of
+// them:
+//
+//	pdb_setvar <name> <expr or "string"> [r=...]
+//	pdb_vars
+func takeVarInput(input string, t *tui.TUI) bool {
+	if !strings.HasPrefix(input, "pdb_setvar") && input != "pdb_vars" {
+		return false
+	}
+	command, ranks := parseInput(input)
+	if command == "vars" {
+		go t.ShowMessagesAll(varsText())
+		return true
+	}
+
+	fields := strings.SplitN(command, " ", 3)
+	if len(fields) != 3 || !isIdentifier(fields[1]) {
+		go t.ShowMessagesAll("Usage: pdb_setvar <name> <expr or \"string\"> [r=...]")
+		return true
+	}
+	if fields[1] == "rank" || fields[1] == "size" {
+		go t.ShowMessagesAll(fmt.Sprintf("$%s cannot be set", fields[1]))
+		return true
+	}
+	if ranks == nil {
+		ranks = allRanks()
+	}
+	if err := setRankVar(fields[1], strings.TrimSpace(fields[2]), ranks); err != nil {
+		go t.ShowMessagesAll(err.Error())
+	}
+	return true
+}
+
+// setRankVar sets a variable for ranks. A quoted value is a string, and
+// anything else an expression evaluated for every rank.
+func setRankVar(name string, value string, ranks []int) error {
+	values := make(map[int]string)
+	for _, rank := range ranks {
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			values[rank] = unquoted
+			continue
+		}
+		n, err := evalTemplateExpr(value, rank)
+		if err != nil {
+			return err
+		}
+		values[rank] = strconv.FormatInt(n, 10)
+	}
+
+	rankVars.mux.Lock()
+	for rank, v := range values {
+		if rankVars.vars[rank] == nil {
+			rankVars.vars[rank] = make(map[string]string)
+		}
+		rankVars.vars[rank][name] = v
+	}
+	rankVars.mux.Unlock()
+	return nil
+}
+
+// varsText tells the value of every variable, grouping the ranks with the
+// same value.
+func varsText() string {
+	rankVars.mux.Lock()
+	defer rankVars.mux.Unlock()
+
+	byName := make(map[string]map[string][]int)
+	for rank, vars := range rankVars.vars {
+		for name, value := range vars {
+			if byName[name] == nil {
+				byName[name] = make(map[string][]int)
+			}
+			byName[name][value] = append(byName[name][value], rank)
+		}
+	}
+	var names []string
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	s := "Variables:\n"
+	if len(names) == 0 {
+		s += "None, besides $rank and $size\n"
+	}
+	for _, name := range names {
+		var values []string
+		for value, ranks := range byName[name] {
+			values = append(values, fmt.Sprintf("%s: %q", formatRanks(ranks), value))
+		}
+		sort.Strings(values)
+		s += fmt.Sprintf("$%s %s\n", name, strings.Join(values, " "))
+	}
+	return s
+}
+
+// expandTemplate expands a command for a rank.
+func expandTemplate(command string, rank int) (string, error) {
+	if !strings.Contains(command, "$") {
+		return command, nil
+	}
+
+	var out strings.Builder
+	for i := 0; i < len(command); {
+		if command[i] != '$' || i+1 == len(command) {
+			out.WriteByte(command[i])
+			i++
+			continue
+		}
+
+		if command[i+1] == '{' {
+			end := strings.IndexByte(command[i:], '}')
+			if end == -1 {
+				return "", fmt.Errorf("missing } in %s", command[i:])
+			}
+			n, err := evalTemplateExpr(command[i+2:i+end], rank)
+			if err != nil {
+				return "", err
+			}
+			out.WriteString(strconv.FormatInt(n, 10))
+			i += end + 1
+			continue
+		}
+
+		j := i + 1
+		for j < len(command) && isIdentChar(rune(command[j]), j == i+1) {
+			j++
+		}
+		if value, ok := rankVar(rank, command[i+1:j]); ok && j > i+1 {
+			out.WriteString(value)
+		} else {
+			out.WriteString(command[i:j])
+		}
+		i = j
+	}
+	return out.String(), nil
+}
+
+func isIdentChar(c rune, first bool) bool {
+	return c == '_' || unicode.IsLetter(c) || (!first && unicode.IsDigit(c))
+}
+
+func isIdentifier(s string) bool {
+	for i, c := range s {
+		if !isIdentChar(c, i == 0) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// evalTemplateExpr evaluates an expression of a template for a rank.
+func evalTemplateExpr(expr string, rank int) (int64, error) {
+	p := &exprParser{rank: rank}
+	if err := p.tokenize(expr); err != nil {
+		return 0, err
+	}
+	n, err := p.ternary()
+	if err != nil {
+		return 0, err
+	}
+	if p.pos != len(p.tokens) {
+		return 0, fmt.Errorf("unexpected %s in %s", p.tokens[p.pos], expr)
+	}
+	return n, nil
+}
+
+// exprParser evaluates expressions while parsing them, by recursive
+// descent with one function per level of precedence. As in C, the branch
+// of ?: not taken and the right side of && and || when the left decides
+// are only parsed, with skip set, so that ${rank==0 ? 0 : 100/rank} works.
+type exprParser struct {
+	rank   int
+	tokens []string
+	pos    int
+	skip   bool
+}
+
+var exprOperators = []string{
+	"==", "!=", "<=", ">=", "&&", "||",
+	"+", "-", "*", "/", "%", "<", ">", "!", "?", ":", "(", ")",
+}
+
+func (p *exprParser) tokenize(expr string) error {
+	for i := 0; i < len(expr); {
+		c := rune(expr[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case unicode.IsDigit(c) || isIdentChar(c, true):
+			j := i + 1
+			for j < len(expr) && isIdentChar(rune(expr[j]), false) {
+				j++
+			}
+			p.tokens = append(p.tokens, expr[i:j])
+			i = j
+		default:
+			found := false
+			for _, op := range exprOperators {
+				if strings.HasPrefix(expr[i:], op) {
+					p.tokens = append(p.tokens, op)
+					i += len(op)
+					found = true
+					break
+				}
+			}
+			if !found {
+				return fmt.Errorf("unexpected %c in %s", c, expr)
+			}
+		}
+	}
+	return nil
+}
+
+func (p *exprParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *exprParser) expect(token string) error {
+	if p.peek() != token {
+		return fmt.Errorf("expected %s", token)
+	}
+	p.pos++
+	return nil
+}
+
+// skipping parses with parse, only evaluating if skip is false.
+func (p *exprParser) skipping(skip bool, parse func() (int64, error)) (int64, error) {
+	saved := p.skip
+	p.skip = saved || skip
+	n, err := parse()
+	p.skip = saved
+	return n, err
+}
+
+func (p *exprParser) ternary() (int64, error) {
+	cond, err := p.binary(0)
+	if err != nil || p.peek() != "?" {
+		return cond, err
+	}
+	p.pos++
+	a, err := p.skipping(cond == 0, p.ternary)
+	if err != nil {
+		return 0, err
+	}
+	if err := p.expect(":"); err != nil {
+		return 0, err
+	}
+	b, err := p.skipping(cond != 0, p.ternary)
+	if err != nil {
+		return 0, err
+	}
+	if cond != 0 {
+		return a, nil
+	}
+	return b, nil
+}
+
+// exprLevels holds the binary operators by precedence, lowest first.
+var exprLevels = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!="},
+	{"<", "<=", ">", ">="},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *exprParser) binary(level int) (int64, error) {
+	if level == len(exprLevels) {
+		return p.unary()
+	}
+	a, err := p.binary(level + 1)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		op := p.peek()
+		if !containsString(exprLevels[level], op) {
+			return a, nil
+		}
+		p.pos++
+		decided := (op == "&&" && a == 0) || (op == "||" && a != 0)
+		b, err := p.skipping(decided, func() (int64, error) { return p.binary(level + 1) })
+		if err != nil {
+			return 0, err
+		}
+		if p.skip {
+			continue
+		}
+		if a, err = applyOperator(op, a, b); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (p *exprParser) unary() (int64, error) {
+	switch p.peek() {
+	case "-":
+		p.pos++
+		n, err := p.unary()
+		return -n, err
+	case "!":
+		p.pos++
+		n, err := p.unary()
+		return boolToInt(n == 0), err
+	case "(":
+		p.pos++
+		n, err := p.ternary()
+		if err != nil {
+			return 0, err
+		}
+		return n, p.expect(")")
+	case "":
+		return 0, fmt.Errorf("unexpected end of expression")
+	}
+
+	token := p.tokens[p.pos]
+	p.pos++
+	if n, err := strconv.ParseInt(token, 0, 64); err == nil {
+		return n, nil
+	}
+	if !isIdentifier(token) {
+		return 0, fmt.Errorf("unexpected %s", token)
+	}
+	if p.skip {
+		return 0, nil
+	}
+	value, ok := rankVar(p.rank, token)
+	if !ok {
+		return 0, fmt.Errorf("$%s is not set for rank %d", token, p.rank)
+	}
+	n, err := strconv.ParseInt(value, 0, 64)
+	if err != nil {
+		return 0, fmt.Errorf("$%s is not a number for rank %d", token, p.rank)
+	}
+	return n, nil
+}
+
+func applyOperator(op string, a int64, b int64) (int64, error) {
+	switch op {
+	case "||":
+		return boolToInt(a != 0 || b != 0), nil
+	case "&&":
+		return boolToInt(a != 0 && b != 0), nil
+	case "==":
+		return boolToInt(a == b), nil
+	case "!=":
+		return boolToInt(a != b), nil
+	case "<":
+		return boolToInt(a < b), nil
+	case "<=":
+		return boolToInt(a <= b), nil
+	case ">":
+		return boolToInt(a > b), nil
+	case ">=":
+		return boolToInt(a >= b), nil
+	case "+":
+		return a + b, nil
+	case "-":
+		return a - b, nil
+	case "*":
+		return a * b, nil
+	case "/", "%":
+		if b == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		if op == "/" {
+			return a / b, nil
+		}
+		return a % b, nil
+	}
+	return 0, fmt.Errorf("unknown operator %s", op)
+}
+
+func boolToInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExpandTemplate(t *testing.T) {
+	defer resetRankVars()
+	rankVars.vars = map[int]map[string]string{
+		0: {"n": "4", "name": "foo"},
+		2: {"n": "0x10", "name": "bar"},
+	}
+
+	tests := []struct {
+		command string
+		rank    int
+		want    string
+		// err is part of the error wanted, if any.
+		err string
+	}{
+		{"print x", 0, "print x", ""},
+		{"print $rank", 2, "print 2", ""},
+		{"print $n+$rank", 2, "print 0x10+2", ""},
+		{"print $name", 0, "print foo", ""},
+		{"print $pc $_exitcode $1", 0, "print $pc $_exitcode $1", ""},
+		{"print $n", 1, "print $n", ""},
+		{"print $", 0, "print $", ""},
+
+		// Precedence and unary operators.
+		{"${1+2*3}", 0, "7", ""},
+		{"${(1+2)*3}", 0, "9", ""},
+		{"${10-4-3}", 0, "3", ""},
+		{"${7/2*2 + 7%2}", 0, "7", ""},
+		{"${1 < 2 == 1}", 0, "1", ""},
+		{"${1 || 0 && 0}", 0, "1", ""},
+		{"${-3*-2}", 0, "6", ""},
+		{"${-(1+2)}", 0, "-3", ""},
+		{"${!0 + !5 + !!5}", 0, "2", ""},
+		{"${n*2}", 2, "32", ""},
+
+		// The ternary operator, and what is not evaluated.
+		{"${rank==0 ? 2 : 0}", 0, "2", ""},
+		{"${rank==0 ? 2 : 0}", 1, "0", ""},
+		{"${rank ? rank>1 ? 2 : 1 : 0}", 2, "2", ""},
+		{"${rank==0 ? 0 : 100/rank}", 0, "0", ""},
+		{"${rank==0 ? 0 : 100/rank}", 4, "25", ""},
+		{"${rank!=0 && 10/rank>1}", 0, "0", ""},
+		{"${rank!=0 && 10/rank>1}", 4, "1", ""},
+		{"${rank==0 || 10/rank>1}", 0, "1", ""},
+		{"${rank==0 || unset}", 0, "1", ""},
+		{"${rank ? unset : 1}", 0, "1", ""},
+		{"${0 && (1/0 ? 1/0 : 1/0)}", 0, "0", ""},
+		{"b f if i == ${rank*10}", 3, "b f if i == 30", ""},
+
+		// Errors.
+		{"print ${rank", 0, "", "missing }"},
+		{"${1/0}", 0, "", "division by zero"},
+		{"${rank%rank}", 0, "", "division by zero"},
+		{"${rank ? 1/0 : 1}", 1, "", "division by zero"},
+		{"${unset}", 0, "", "not set"},
+		{"${name+1}", 0, "", "not a number"},
+		{"${1+}", 0, "", "unexpected end"},
+		{"${(1+2}", 0, "", "expected )"},
+		{"${1 ? 2}", 0, "", "expected :"},
+		{"${1 2}", 0, "", "unexpected 2"},
+		{"${1 & 2}", 0, "", "unexpected &"},
+	}
+	for _, test := range tests {
+		got, err := expandTemplate(test.command, test.rank)
+		switch {
+		case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+			t.Errorf("expandTemplate(%q, %d) = %q, %v, want an error with %q", test.command, test.rank, got, err, test.err)
+		case test.err == "" && (err != nil || got != test.want):
+			t.Errorf("expandTemplate(%q, %d) = %q, %v, want %q", test.command, test.rank, got, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"fmt"
+	htmlTemplate "html/template"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"git.cse.iitk.ac.in/ssaha/parallel-debugger/pd-server/tui"
+)
+
+// transcript holds the commands sent to the clients in the session, each
+// with what every rank printed after it, for pdb_export. Like the panes, it
+// keeps only the last pane-lines lines of every rank.
+var transcript struct {
+	blocks []*transcriptBlock
+	// kept is how many lines of every rank the blocks hold.
+	kept map[int]int
+	mux  sync.Mutex
+}
+
+type transcriptBlock struct {
+	time time.Time
+	// input is empty for what was printed before the first command.
+	input  string
+	ranks  []int
+	output map[int][]string
+	// dropped is how many lines printed after the command were dropped.
+	dropped int
+}
+
+func resetTranscript() {
+	transcript.mux.Lock()
+	transcript.blocks = nil
+	transcript.kept = nil
+	transcript.mux.Unlock()
+}
+
+// transcriptCommand starts a new block for a command sent to ranks, or to
+// all the ranks if ranks is nil.
+func transcriptCommand(input string, ranks []int, at time.Time) {
+	transcript.mux.Lock()
+	transcript.blocks = append(transcript.blocks, &transcriptBlock{
+		time:   at,
+		input:  input,
+		ranks:  ranks,
+		output: make(map[int][]string),
+	})
+	transcript.mux.Unlock()
+}
+
+// transcriptOutput adds a line printed by a rank to the last block.
+func transcriptOutput(rank int, line string) {
+	transcript.mux.Lock()
+	defer transcript.mux.Unlock()
+	if len(transcript.blocks) == 0 {
+		transcript.blocks = append(transcript.blocks, &transcriptBlock{
+			time:   time.Now(),
+			output: make(map[int][]string),
+		})
+	}
+	block := transcript.blocks[len(transcript.blocks)-1]
+	block.output[rank] = append(block.output[rank], line)
+
+	if transcript.kept == nil {
+		transcript.kept = make(map[int]int)
+	}
+	transcript.kept[rank]++
+	// As with the panes, the oldest lines are dropped all at once.
+	if limit := *paneLines; limit > 0 && transcript.kept[rank] > limit+limit/8 {
+		dropTranscript(rank, transcript.kept[rank]-limit)
+	}
+}
+
+// dropTranscript drops the n oldest lines of a rank from the blocks.
+// transcript.mux must be held by the caller.
+func dropTranscript(rank int, n int) {
+	transcript.kept[rank] -= n
+	for _, block := range transcript.blocks {
+		if n == 0 {
+			return
+		}
+		lines, ok := block.output[rank]
+		if !ok {
+			continue
+		}
+		if len(lines) <= n {
+			delete(block.output, rank)
+			block.dropped += len(lines)
+			n -= len(lines)
+			continue
+		}
+		block.output[rank] = append([]string(nil), lines[n:]...)
+		block.dropped += n
+		n = 0
+	}
+}
+
+// exportData is what goes into an exported transcript.
+type exportData struct {
+	Exported string
+	Size     int
+	// Kept is how many lines of every rank the transcript keeps at most.
+	Kept        int
+	Commands    []exportCommand
+	States      []exportState
+	Collectives []exportCollective
+}
+
+type exportCommand struct {
+	Time  string
+	Input string
+	Ranks string
+	// Outputs holds the output of the ranks, where the ranks which printed
+	// the same lines share one entry.
+	Outputs []exportOutput
+	// Dropped is how many lines of the output were dropped to keep only
+	// the last Kept lines of every rank.
+	Dropped int
+}
+
+type exportOutput struct {
+	Ranks string
+	Lines []string
+}
+
+type exportState struct {
+	Rank    int
+	Summary string
+	Reason  string
+	Frames  []string
+}
+
+type exportCollective struct {
+	Function string
+	// Callers holds where the ranks called the collective, where those
+	// which did not call it yet are "pending".
+	Callers []exportOutput
+}
+
+// exportTranscript writes the transcript of the session to path, as
+// Markdown if it ends with .md and as HTML otherwise.
+func exportTranscript(path string, t *tui.TUI) {
+	f, err := os.Create(path)
+	if err == nil {
+		data := transcriptData()
+		ext := strings.ToLower(filepath.Ext(path))
+		if ext == ".md" || ext == ".markdown" {
+			err = markdownTranscript.Execute(f, data)
+		} else {
+			err = htmlTranscript.Execute(f, data)
+		}
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		t.ShowMessagesAll(fmt.Sprintf("Could not export the transcript: %s", err.Error()))
+		return
+	}
+	t.ShowMessagesAll(fmt.Sprintf("Transcript written to %s", path))
+}
+
+func transcriptData() exportData {
+	data := exportData{
+		Exported: time.Now().Format("2006-01-02 15:04:05"),
+		Size:     len(connections),
+		Kept:     *paneLines,
+	}
+
+	transcript.mux.Lock()
+	for _, block := range transcript.blocks {
+		c := exportCommand{
+			Time:  block.time.Format("15:04:05"),
+			Input: block.input,
+			Ranks: "all",
+		}
+		if block.ranks != nil {
+			c.Ranks = formatRanks(block.ranks)
+		}
+		c.Outputs = groupOutputs(block.output)
+		c.Dropped = block.dropped
+		data.Commands = append(data.Commands, c)
+	}
+	transcript.mux.Unlock()
+
+	var ranks []int
+	for rank := range connections {
+		ranks = append(ranks, rank)
+	}
+	sort.Ints(ranks)
+
+	rankStates.mux.Lock()
+	for _, rank := range ranks {
+		s := exportState{Rank: rank, Summary: "unknown"}
+		if info, ok := rankStates.states[rank]; ok {
+			s.Summary = stateSummary(*info)
+			s.Reason = info.Reason
+		}
+		if info, ok := rankStates.crashes[rank]; ok {
+			s.Frames = info.Frames
+		}
+		data.States = append(data.States, s)
+	}
+	rankStates.mux.Unlock()
+
+	for _, call := range pendingCollectiveInfo() {
+		where := make(map[int][]string)
+		for _, rank := range ranks {
+			if info, ok := call.callers[rank]; ok {
+				where[rank] = []string{"called at " + info.LineInfo}
+			} else {
+				where[rank] = []string{"pending"}
+			}
+		}
+		data.Collectives = append(data.Collectives, exportCollective{
+			Function: call.funcName,
+			Callers:  groupOutputs(where),
+		})
+	}
+	return data
+}
+
+// groupOutputs groups the ranks which have the same lines, in the order of
+// their lowest rank.
+func groupOutputs(lines map[int][]string) []exportOutput {
+	var ranks []int
+	for rank := range lines {
+		ranks = append(ranks, rank)
+	}
+	sort.Ints(ranks)
+
+	groups := make(map[string][]int)
+	var keys []string
+	for _, rank := range ranks {
+		key := strings.Join(lines[rank], "\n")
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], rank)
+	}
+
+	var outputs []exportOutput
+	for _, key := range keys {
+		outputs = append(outputs, exportOutput{
+			Ranks: formatRanks(groups[key]),
+			Lines: lines[groups[key][0]],
+		})
+	}
+	return outputs
+}
+
+// markdownFuncs keep what the ranks printed from breaking the Markdown of
+// a transcript.
+var markdownFuncs = template.FuncMap{
+	// fence gives a code fence longer than any run of backticks in lines.
+	"fence": func(lines []string) string {
+		n := 3
+		for _, line := range lines {
+			if run := backtickRun(line) + 1; run > n {
+				n = run
+			}
+		}
+		return strings.Repeat("`", n)
+	},
+	// code gives s as inline code, between more backticks than it has in a
+	// row, and spaces if it starts or ends with one.
+	"code": func(s string) string {
+		quote := strings.Repeat("`", backtickRun(s)+1)
+		if strings.HasPrefix(s, "`") || strings.HasSuffix(s, "`") {
+			s = " " + s + " "
+		}
+		return quote + s + quote
+	},
+	// cell escapes s for a table cell, which ends at a | or a newline.
+	"cell": func(s string) string {
+		s = strings.Replace(s, "|", "\\|", -1)
+		return strings.Replace(s, "\n", "<br>", -1)
+	},
+}
+
+// backtickRun gives the length of the longest run of backticks in s.
+func backtickRun(s string) (longest int) {
+	run := 0
+	for _, c := range s {
+		if c != '`' {
+			run = 0
+			continue
+		}
+		run++
+		if run > longest {
+			longest = run
+		}
+	}
+	return
+}
+
+var markdownTranscript = template.Must(template.New("markdown").Funcs(markdownFuncs).Parse(`# pd session transcript
+
+Exported {{.Exported}}, {{.Size}} ranks.
+
+## Commands
+{{range .Commands}}
+### {{if .Input}}{{code .Input}} on {{.Ranks}}{{else}}Before the first command{{end}} ({{.Time}})
+{{if .Dropped}}
+{{.Dropped}} lines dropped, only the last {{$.Kept}} lines of every rank are kept.
+{{end}}{{range .Outputs}}
+Ranks {{.Ranks}}:
+
+{{$fence := fence .Lines}}{{$fence}}
+{{range .Lines}}{{.}}
+{{end}}{{$fence}}
+{{else}}{{if not .Dropped}}
+No output.
+{{end}}{{end}}{{end}}
+## Final state
+
+| Rank | State | Reason |
+| ---- | ----- | ------ |
+{{range .States}}| {{.Rank}} | {{cell .Summary}} | {{cell .Reason}} |
+{{end}}{{range .States}}{{if .Frames}}
+Rank {{.Rank}} crashed in:
+
+{{$fence := fence .Frames}}{{$fence}}
+{{range $i, $f := .Frames}}#{{$i}} {{$f}}
+{{end}}{{$fence}}
+{{end}}{{end}}
+## Pending collectives
+{{range .Collectives}}
+### {{.Function}}
+
+| Ranks | Status |
+| ----- | ------ |
+{{range .Callers}}| {{.Ranks}} | {{cell (index .Lines 0)}} |
+{{end}}{{else}}
+None.
+{{end}}`))
+
+var htmlTranscript = htmlTemplate.Must(htmlTemplate.New("html").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>pd session transcript</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+pre { background: #f4f4f4; padding: 0.5em; overflow-x: auto; }
+table { border-collapse: collapse; }
+td, th { border: 1px solid #ccc; padding: 0.2em 0.6em; text-align: left; }
+summary { cursor: pointer; }
+.command { margin-bottom: 1em; }
+.time, .dropped { color: #888; }
+</style>
+</head>
+<body>
+<h1>pd session transcript</h1>
+<p>Exported {{.Exported}}, {{.Size}} ranks.</p>
+
+<h2>Commands</h2>
+{{range .Commands}}<div class="command">
+<h3>{{if .Input}}<code>{{.Input}}</code> on {{.Ranks}}{{else}}Before the first command{{end}} <span class="time">{{.Time}}</span></h3>
+{{if .Dropped}}<p class="dropped">{{.Dropped}} lines dropped, only the last {{$.Kept}} lines of every rank are kept.</p>
+{{end}}{{range .Outputs}}<details>
+<summary>Ranks {{.Ranks}} ({{len .Lines}} lines)</summary>
+<pre>{{range .Lines}}{{.}}
+{{end}}</pre>
+</details>
+{{else}}{{if not .Dropped}}<p>No output.</p>
+{{end}}{{end}}</div>
+{{end}}
+<h2>Final state</h2>
+<table>
+<tr><th>Rank</th><th>State</th><th>Reason</th></tr>
+{{range .States}}<tr><td>{{.Rank}}</td><td>{{.Summary}}</td><td>{{.Reason}}</td></tr>
+{{end}}</table>
+{{range .States}}{{if .Frames}}<details open>
+<summary>Rank {{.Rank}} crashed in</summary>
+<pre>{{range $i, $f := .Frames}}#{{$i}} {{$f}}
+{{end}}</pre>
+</details>
+{{end}}{{end}}
+<h2>Pending collectives</h2>
+{{range .Collectives}}<h3>{{.Function}}</h3>
+<table>
+<tr><th>Ranks</th><th>Status</th></tr>
+{{range .Callers}}<tr><td>{{.Ranks}}</td><td>{{index .Lines 0}}</td></tr>
+{{end}}</table>
+{{else}}<p>None.</p>
+{{end}}</body>
+</html>
+`))
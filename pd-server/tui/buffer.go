@@ -0,0 +1,52 @@
+package tui
+
+import (
+	"fmt"
+
+	tui "github.com/marcusolsson/tui-go"
+)
+
+// LimitHistory keeps only the last lines messages of every rank, so that a
+// rank which prints without end cannot use up the memory of the server.
+// spillFile names the file in which the output of a rank is kept in full,
+// which the panes point to once they drop messages, or is nil.
+func (t *TUI) LimitHistory(lines int, spillFile func(rank int) string) {
+	t.limit = lines
+	t.spillFile = spillFile
+}
+
+// addMessage adds a message to the history of a rank and to its pane.
+// Once the history holds an eighth more than the limit, the oldest messages
+// are dropped all at once, and the pane filled again.
+func (t *TUI) addMessage(rank int, message string) {
+	t.history[rank] = append(t.history[rank], message)
+	h := t.history[rank]
+	if t.limit <= 0 || len(h) <= t.limit+t.limit/8 {
+		t.appendLine(rank, message)
+		return
+	}
+
+	drop := len(h) - t.limit
+	t.history[rank] = append([]string(nil), h[drop:]...)
+	t.dropped[rank] += drop
+	t.fillPane(rank)
+}
+
+// appendDroppedNote tells at the top of a pane how many older messages
+// were dropped, and where to find them.
+func (t *TUI) appendDroppedNote(rank int) {
+	if t.dropped[rank] == 0 {
+		return
+	}
+	note := fmt.Sprintf("(%d older messages dropped)", t.dropped[rank])
+	if t.spillFile != nil {
+		if file := t.spillFile(rank); file != "" {
+			note = fmt.Sprintf("(%d older messages dropped, the output is kept in %s)", t.dropped[rank], file)
+		}
+	}
+	t.clients[rank].Append(tui.NewHBox(
+		tui.NewPadder(1, 0, tui.NewLabel(note)),
+		tui.NewSpacer(),
+	))
+	t.rows[rank]++
+}
@@ -0,0 +1,102 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	tui "github.com/marcusolsson/tui-go"
+)
+
+// maxCompletionRows is how many rows of candidates the popup shows at most.
+const maxCompletionRows = 8
+
+// OnComplete sets the function which completes the input when Tab is
+// pressed. It gives back the completed input, along with the candidates to
+// show if there is more than one. It runs on the UI goroutine, so it must
+// not wait for anything.
+func (t *TUI) OnComplete(fn func(input string) (string, []string)) {
+	t.completer = fn
+}
+
+// complete completes the input, and shows the candidates in a popup above
+// it until the input changes.
+func (t *TUI) complete() {
+	if t.completer == nil || t.focus != -1 {
+		return
+	}
+	t.endSearch(true)
+	text, candidates := t.completer(t.Input.Text())
+	if text != t.Input.Text() {
+		t.Input.SetText(text)
+	}
+	if len(candidates) == 0 {
+		t.hideCompletions()
+		return
+	}
+	t.showCompletions(candidates)
+}
+
+// showCompletions lays the candidates out in columns as wide as the
+// longest of them.
+func (t *TUI) showCompletions(candidates []string) {
+	width := 0
+	for _, c := range candidates {
+		if w := utf8.RuneCountInString(c); w > width {
+			width = w
+		}
+	}
+	width += 2
+	columns := (t.Input.Size().X - 2) / width
+	if columns < 1 {
+		columns = 1
+	}
+
+	var rows []string
+	for i := 0; i < len(candidates); i += columns {
+		end := i + columns
+		if end > len(candidates) {
+			end = len(candidates)
+		}
+		row := ""
+		for _, c := range candidates[i:end] {
+			row += c + strings.Repeat(" ", width-utf8.RuneCountInString(c))
+		}
+		rows = append(rows, strings.TrimRight(row, " "))
+	}
+	if len(rows) > maxCompletionRows {
+		hidden := len(candidates) - (maxCompletionRows-1)*columns
+		rows = append(rows[:maxCompletionRows-1], fmt.Sprintf("... and %d more", hidden))
+	}
+
+	t.showPopup("completions", strings.Join(rows, "\n"))
+}
+
+// showPopup shows text in the popup right above the input, which shows the
+// candidates for completing it or the reverse search of the history.
+func (t *TUI) showPopup(title string, text string) {
+	t.completions.SetText(text)
+	t.completionsPane.SetTitle(title)
+	if !t.completionsShown {
+		// The popup goes right above the input, which is the last widget.
+		t.root.Insert(t.root.Length()-1, t.completionsPane)
+		t.completionsShown = true
+	}
+}
+
+func (t *TUI) hideCompletions() {
+	if !t.completionsShown {
+		return
+	}
+	t.root.Remove(t.root.Length() - 2)
+	t.completionsShown = false
+}
+
+// newCompletionsPane makes the popup above the input.
+func newCompletionsPane() (*tui.Label, *tui.Box) {
+	label := tui.NewLabel("")
+	pane := tui.NewVBox(tui.NewHBox(tui.NewPadder(1, 0, label), tui.NewSpacer()))
+	pane.SetBorder(true)
+	pane.SetSizePolicy(tui.Expanding, tui.Maximum)
+	return label, pane
+}
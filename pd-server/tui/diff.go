@@ -0,0 +1,167 @@
+package tui
+
+import (
+	tui "github.com/marcusolsson/tui-go"
+)
+
+// DiffChange tells how the two sides of a row of a diff differ.
+type DiffChange int
+
+const (
+	// DiffSame is a line both sides have.
+	DiffSame DiffChange = iota
+	// DiffChanged is a line which is different on the right.
+	DiffChanged
+	// DiffRemoved is a line only the left side has, and DiffAdded one
+	// only the right side has.
+	DiffRemoved
+	DiffAdded
+)
+
+// DiffRow is a row of a side-by-side diff.
+type DiffRow struct {
+	Left   string
+	Right  string
+	Change DiffChange
+}
+
+// setDiffStyles sets the colors of the rows of a diff which differ.
+func setDiffStyles(theme *tui.Theme) {
+	theme.SetStyle("label.diff.changed", tui.Style{Fg: tui.ColorYellow})
+	theme.SetStyle("label.diff.removed", tui.Style{Fg: tui.ColorRed})
+	theme.SetStyle("label.diff.added", tui.Style{Fg: tui.ColorGreen})
+	theme.SetStyle("label.diff.header", tui.Style{Bold: tui.DecorationOn})
+}
+
+// setDiffKeys sets the keys of the diff, which is shown in place of the
+// panes while the input has the focus:
+//
+//	PgUp, PgDn           scroll the diff by a page
+//	Home, End            scroll to the top or the bottom of the diff
+//	Esc                  show the panes again
+//
+// Giving a pane the focus shows the panes again as well.
+func (t *TUI) setDiffKeys() {
+	t.ui.SetKeybinding("PgUp", func() {
+		if t.focus == -1 {
+			t.scrollDiff(t.diffTop - t.diffPage())
+		}
+	})
+	t.ui.SetKeybinding("PgDn", func() {
+		if t.focus == -1 {
+			t.scrollDiff(t.diffTop + t.diffPage())
+		}
+	})
+	t.ui.SetKeybinding("Home", func() {
+		if t.focus == -1 {
+			t.scrollDiff(0)
+		}
+	})
+	t.ui.SetKeybinding("End", func() {
+		if t.focus == -1 {
+			t.scrollDiff(len(t.diffRows))
+		}
+	})
+	t.ui.SetKeybinding("Esc", func() {
+		if t.focus == -1 && !t.searching {
+			t.hideDiff()
+		}
+	})
+}
+
+// ShowDiff shows a side-by-side diff in place of the panes, with the lines
+// which differ in color, under a title and the names of both sides.
+func (t *TUI) ShowDiff(title string, left string, right string, rows []DiffRow) {
+	t.ui.Update(func() {
+		lines := tui.NewVBox()
+		lines.Append(diffRow(DiffRow{Left: left, Right: right}, "diff.header"))
+		for _, row := range rows {
+			lines.Append(diffRow(row, diffStyle(row.Change)))
+		}
+		lines.Append(tui.NewSpacer())
+
+		t.diffRows = rows
+		t.diffTop = 0
+		t.diffScroller = tui.NewScrollArea(lines)
+		t.diffPane = tui.NewVBox(t.diffScroller)
+		t.diffPane.SetBorder(true)
+		t.diffPane.SetTitle(title + " (Esc to close)")
+		t.diffPane.SetSizePolicy(tui.Expanding, tui.Expanding)
+		t.setFocus(-1)
+		t.arrange()
+	})
+}
+
+// diffRow lays out a row of a diff, where both sides get half the width.
+func diffRow(row DiffRow, style string) *tui.Box {
+	left := tui.NewLabel(" " + row.Left)
+	right := tui.NewLabel(row.Right)
+	for _, label := range []*tui.Label{left, right} {
+		label.SetSizePolicy(tui.Expanding, tui.Preferred)
+		label.SetStyleName(style)
+	}
+	mark := " │ "
+	switch row.Change {
+	case DiffChanged:
+		mark = " | "
+	case DiffRemoved:
+		mark = " < "
+	case DiffAdded:
+		mark = " > "
+	}
+	sep := tui.NewLabel(mark)
+	sep.SetStyleName(style)
+	return tui.NewHBox(left, sep, right)
+}
+
+func diffStyle(change DiffChange) string {
+	switch change {
+	case DiffChanged:
+		return "diff.changed"
+	case DiffRemoved:
+		return "diff.removed"
+	case DiffAdded:
+		return "diff.added"
+	}
+	return ""
+}
+
+// hideDiff shows the panes again in place of the diff.
+func (t *TUI) hideDiff() {
+	if t.diffPane == nil {
+		return
+	}
+	t.diffPane = nil
+	t.diffScroller = nil
+	t.diffRows = nil
+	t.arrange()
+}
+
+// diffPage is how far PgUp and PgDn scroll the diff.
+func (t *TUI) diffPage() int {
+	if t.diffScroller == nil {
+		return 0
+	}
+	if h := t.diffScroller.Size().Y - 1; h > 1 {
+		return h
+	}
+	return 1
+}
+
+// scrollDiff scrolls the diff so that its row top is at the top.
+func (t *TUI) scrollDiff(top int) {
+	if t.diffScroller == nil {
+		return
+	}
+	// The names of the sides take a row above the rows of the diff.
+	bottom := len(t.diffRows) + 1 - t.diffScroller.Size().Y
+	if top > bottom {
+		top = bottom
+	}
+	if top < 0 {
+		top = 0
+	}
+	t.diffTop = top
+	t.diffScroller.ScrollToTop()
+	t.diffScroller.Scroll(0, top)
+}
@@ -0,0 +1,118 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SetCmdHistory sets the commands browsed with Up and Down and searched
+// with Ctrl+R, e.g. the ones saved by earlier sessions.
+func (t *TUI) SetCmdHistory(hist []string) {
+	t.cmdHistory = append([]string(nil), hist...)
+	t.histPtr = len(t.cmdHistory)
+}
+
+// setSearchKeys sets the keys of the reverse incremental search through
+// the command history, which works like the one of readline: Ctrl+R starts
+// it, the input then holds what is searched for, and the popup above it
+// shows the most recent command which contains it.
+//
+//	Ctrl+R               find an older command
+//	Enter                run the command which was found
+//	Up, Down, Tab        take the command into the input to edit it
+//	Esc, Ctrl+G          give the input back as it was
+func (t *TUI) setSearchKeys() {
+	t.ui.SetKeybinding("Ctrl+R", func() {
+		if t.focus != -1 {
+			return
+		}
+		if !t.searching {
+			t.startSearch()
+			return
+		}
+		t.search(t.searchMatch-1, true)
+	})
+	t.ui.SetKeybinding("Enter", func() {
+		if t.searching {
+			t.endSearch(true)
+		}
+	})
+	t.ui.SetKeybinding("Esc", func() {
+		if t.searching {
+			t.endSearch(false)
+		}
+	})
+	t.ui.SetKeybinding("Ctrl+G", func() {
+		if t.searching {
+			t.endSearch(false)
+		}
+	})
+}
+
+func (t *TUI) startSearch() {
+	t.searching = true
+	t.searchSaved = t.Input.Text()
+	t.searchMatch = len(t.cmdHistory)
+	t.Input.SetText("")
+	t.showSearch(false)
+}
+
+// search finds the most recent command from the one at from backwards
+// which contains the input. When older is true, a command which is the
+// same as the one found already is skipped.
+func (t *TUI) search(from int, older bool) {
+	query := t.Input.Text()
+	if query == "" {
+		t.searchMatch = len(t.cmdHistory)
+		t.showSearch(false)
+		return
+	}
+	current := ""
+	if t.searchMatch < len(t.cmdHistory) {
+		current = t.cmdHistory[t.searchMatch]
+	}
+	for i := from; i >= 0; i-- {
+		cmd := t.cmdHistory[i]
+		if strings.Contains(cmd, query) && !(older && cmd == current) {
+			t.searchMatch = i
+			t.showSearch(false)
+			return
+		}
+	}
+	t.showSearch(true)
+}
+
+// showSearch shows what is searched for and the command found in the
+// popup above the input.
+func (t *TUI) showSearch(failed bool) {
+	query := t.Input.Text()
+	text := "type to search the command history"
+	switch {
+	case failed:
+		text = fmt.Sprintf("failing `%s'", query)
+		if t.searchMatch < len(t.cmdHistory) {
+			text += ": " + t.cmdHistory[t.searchMatch]
+		}
+	case query != "" && t.searchMatch < len(t.cmdHistory):
+		text = fmt.Sprintf("`%s': %s", query, t.cmdHistory[t.searchMatch])
+	case query != "":
+		text = fmt.Sprintf("failing `%s'", query)
+	}
+	t.showPopup("reverse-i-search (Ctrl+R older, Enter run, Esc cancel)", text)
+}
+
+// endSearch puts the command which was found into the input if accept is
+// true, or the input from before the search otherwise.
+func (t *TUI) endSearch(accept bool) {
+	if !t.searching {
+		return
+	}
+	t.searching = false
+	text := t.searchSaved
+	if accept && t.searchMatch < len(t.cmdHistory) {
+		text = t.cmdHistory[t.searchMatch]
+		t.histPtr = t.searchMatch
+	}
+	t.Input.SetText(text)
+	t.hideCompletions()
+}
@@ -0,0 +1,185 @@
+package tui
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	tui "github.com/marcusolsson/tui-go"
+)
+
+// Layouts are the ways the panes can be arranged in.
+var Layouts = []string{"horizontal", "vertical", "grid", "tabbed", "maximized"}
+
+// Layout is how the panes are arranged, and which ranks have one.
+type Layout struct {
+	// Name is horizontal, vertical, grid or tabbed.
+	Name string
+	// Rows and Cols are those of a grid, or 0 for as many as fit the
+	// panes best.
+	Rows  int `json:",omitempty"`
+	Cols  int `json:",omitempty"`
+	Ranks []int
+	// Maximized tells if only the pane of Current is shown, whatever the
+	// layout. Current is also the rank whose pane the tabbed layout shows.
+	Maximized bool `json:",omitempty"`
+	Current   int
+}
+
+// OnLayout adds a function to be run when the layout or the ranks which
+// have a pane change. It runs on the UI goroutine.
+func (t *TUI) OnLayout(fn func(l Layout)) {
+	t.layoutHooks = append(t.layoutHooks, fn)
+}
+
+// SetLayout arranges the panes in one of Layouts. Rows and cols are only
+// used by the grid, where either may be 0 to fit the panes. The maximized
+// layout shows only the pane which has the focus, or the first one.
+func (t *TUI) SetLayout(name string, rows int, cols int) error {
+	switch name {
+	case "horizontal", "vertical", "grid", "tabbed":
+		t.layout = name
+		t.gridRows, t.gridCols = rows, cols
+		t.maximized = -1
+		t.arrange()
+	case "maximized":
+		ranks := t.shownRanks()
+		if len(ranks) == 0 {
+			return fmt.Errorf("there are no panes to maximize")
+		}
+		rank := ranks[0]
+		if t.focus >= 0 {
+			rank = t.focus
+		}
+		t.maximize(rank)
+	default:
+		return fmt.Errorf("unknown layout %s, use one of %s", name, strings.Join(Layouts, ", "))
+	}
+	return nil
+}
+
+// RestoreLayout shows the panes of the ranks of a saved layout, arranged
+// as they were.
+func (t *TUI) RestoreLayout(l Layout) {
+	t.layout = l.Name
+	if t.layout == "" {
+		t.layout = "horizontal"
+	}
+	t.gridRows, t.gridCols = l.Rows, l.Cols
+	t.current = l.Current
+	t.Show(l.Ranks)
+	if _, ok := t.panes[l.Current]; ok && l.Maximized {
+		t.maximize(l.Current)
+	}
+}
+
+// currentLayout describes the layout the panes are in now.
+func (t *TUI) currentLayout() Layout {
+	l := Layout{
+		Name:    t.layout,
+		Rows:    t.gridRows,
+		Cols:    t.gridCols,
+		Ranks:   t.shownRanks(),
+		Current: t.current,
+	}
+	if t.maximized >= 0 {
+		l.Maximized = true
+		l.Current = t.maximized
+	}
+	return l
+}
+
+// layoutName names the layout for the status bar, e.g. grid 2x3.
+func (t *TUI) layoutName() string {
+	switch {
+	case t.diffPane != nil:
+		return "diff"
+	case t.maximized >= 0:
+		return "maximized"
+	case t.layout == "grid" && (t.gridRows != 0 || t.gridCols != 0):
+		return fmt.Sprintf("grid %dx%d", t.gridRows, t.gridCols)
+	}
+	return t.layout
+}
+
+// arrange puts the panes into clientParent as the layout has them.
+func (t *TUI) arrange() {
+	for t.clientParent.Length() != 0 {
+		t.clientParent.Remove(0)
+	}
+	ranks := t.shownRanks()
+	switch {
+	case t.diffPane != nil:
+		t.clientParent.Append(t.diffPane)
+	case t.maximized >= 0:
+		t.clientParent.Append(t.panes[t.maximized])
+	case len(ranks) == 0:
+	case t.layout == "vertical":
+		for _, rank := range ranks {
+			t.clientParent.Append(t.panes[rank])
+		}
+	case t.layout == "grid":
+		t.arrangeGrid(ranks)
+	case t.layout == "tabbed":
+		t.arrangeTabs(ranks)
+	default:
+		row := tui.NewHBox()
+		for _, rank := range ranks {
+			row.Append(t.panes[rank])
+		}
+		t.clientParent.Append(row)
+	}
+
+	t.drawStatus()
+	l := t.currentLayout()
+	for _, fn := range t.layoutHooks {
+		fn(l)
+	}
+}
+
+// arrangeGrid puts the panes in rows of gridCols. The last row is filled
+// up with empty cells, so that the columns line up.
+func (t *TUI) arrangeGrid(ranks []int) {
+	cols := t.gridCols
+	if cols <= 0 && t.gridRows > 0 {
+		cols = (len(ranks) + t.gridRows - 1) / t.gridRows
+	}
+	if cols <= 0 {
+		cols = int(math.Ceil(math.Sqrt(float64(len(ranks)))))
+	}
+	for i := 0; i < len(ranks); i += cols {
+		row := tui.NewHBox()
+		for j := i; j < i+cols; j++ {
+			if j < len(ranks) {
+				row.Append(t.panes[ranks[j]])
+			} else {
+				empty := tui.NewVBox()
+				empty.SetSizePolicy(tui.Expanding, tui.Expanding)
+				row.Append(empty)
+			}
+		}
+		t.clientParent.Append(row)
+	}
+}
+
+// arrangeTabs shows the pane of the current rank under a row of tabs, one
+// for every pane. Focusing another pane with Ctrl+N or Ctrl+P switches to
+// it.
+func (t *TUI) arrangeTabs(ranks []int) {
+	if !containsRank(ranks, t.current) {
+		t.current = ranks[0]
+	}
+	var tabs []string
+	for _, rank := range ranks {
+		if rank == t.current {
+			tabs = append(tabs, fmt.Sprintf("[rank-%d]", rank))
+		} else {
+			tabs = append(tabs, fmt.Sprintf(" rank-%d ", rank))
+		}
+	}
+	bar := tui.NewLabel(" " + strings.Join(tabs, " ") + "   Ctrl+N, Ctrl+P to switch")
+	bar.SetStyleName("tabs")
+	bar.SetSizePolicy(tui.Expanding, tui.Maximum)
+	t.clientParent.Append(bar)
+	t.clientParent.Append(t.panes[t.current])
+}
@@ -0,0 +1,216 @@
+package tui
+
+import (
+	"sort"
+)
+
+// setNavigationKeys sets the keys which move the focus between the input,
+// the overview of the ranks and their panes, and scroll the focused pane:
+//
+//	Ctrl+N, Ctrl+P       focus the next or previous pane, where the overview
+//	                     comes before the first pane
+//	Esc                  focus the input again
+//	Up, Down             scroll the focused pane by a line
+//	PgUp, PgDn           scroll the focused pane by a page
+//	Home, End            scroll to the top, or follow the output again
+//	Ctrl+F               show only the focused pane, or all of them again,
+//	                     unless the input has the focus, where it moves the
+//	                     cursor forward
+func (t *TUI) setNavigationKeys() {
+	t.ui.SetKeybinding("Ctrl+N", func() { t.cycleFocus(1) })
+	t.ui.SetKeybinding("Ctrl+P", func() { t.cycleFocus(-1) })
+	t.ui.SetKeybinding("Esc", func() { t.setFocus(-1) })
+	t.ui.SetKeybinding("PgUp", func() { t.scroll(-t.pageSize()) })
+	t.ui.SetKeybinding("PgDn", func() { t.scroll(t.pageSize()) })
+	t.ui.SetKeybinding("Home", func() {
+		if t.focus >= 0 {
+			t.scrollTo(t.focus, 0)
+		}
+	})
+	t.ui.SetKeybinding("End", func() {
+		if t.focus >= 0 {
+			t.follow(t.focus)
+		}
+	})
+	t.ui.SetKeybinding("Ctrl+F", t.toggleMaximized)
+}
+
+// OnFocus adds a function to be run when a pane gets the focus, or the
+// input or the overview gets it, with rank -1. It runs on the UI goroutine.
+func (t *TUI) OnFocus(fn func(rank int)) {
+	t.focusHooks = append(t.focusHooks, fn)
+}
+
+// shownRanks gives the ranks which have a pane, in order.
+func (t *TUI) shownRanks() []int {
+	var ranks []int
+	for rank := range t.panes {
+		ranks = append(ranks, rank)
+	}
+	sort.Ints(ranks)
+	return ranks
+}
+
+// cycleFocus moves the focus by step panes, going through the input after
+// the last pane and the overview before the first.
+func (t *TUI) cycleFocus(step int) {
+	ranks := append(append([]int{gridFocus}, t.shownRanks()...), -1)
+	i := len(ranks) - 1
+	for j, rank := range ranks {
+		if rank == t.focus {
+			i = j
+		}
+	}
+	i = (i + step + len(ranks)) % len(ranks)
+	t.setFocus(ranks[i])
+	if t.maximized >= 0 && t.focus >= 0 {
+		t.maximize(t.focus)
+	}
+}
+
+// setFocus gives the focus to the pane of a rank, to the input if rank is
+// -1, or to the overview if it is gridFocus. The input takes no keys while
+// it does not have the focus.
+func (t *TUI) setFocus(rank int) {
+	if rank == t.focus {
+		return
+	}
+	if rank != -1 {
+		t.endSearch(false)
+		t.hideDiff()
+	}
+	old := t.focus
+	t.focus = rank
+	t.Input.SetFocused(rank == -1)
+	if rank == gridFocus && old >= 0 {
+		t.grid.cursor = old
+	}
+	t.grid.selecting = rank == gridFocus
+	t.gridPane.SetTitle(t.gridTitle())
+	for _, r := range []int{old, rank} {
+		if pane, ok := t.panes[r]; ok {
+			pane.SetTitle(t.title(r))
+		}
+	}
+	if t.layout == "tabbed" && rank >= 0 && rank != t.current {
+		t.current = rank
+		t.arrange()
+	} else {
+		t.drawStatus()
+	}
+	if rank < 0 {
+		rank = -1
+	}
+	for _, fn := range t.focusHooks {
+		fn(rank)
+	}
+}
+
+// gridTitle gives the title of the overview, which tells its keys while it
+// has the focus.
+func (t *TUI) gridTitle() string {
+	if t.focus == gridFocus {
+		return "> ranks (arrows to move, Enter to open or close a pane, Esc to leave)"
+	}
+	return "ranks"
+}
+
+// pageSize is how far PgUp and PgDn scroll, which is a little less than the
+// height of the focused pane.
+func (t *TUI) pageSize() int {
+	scroller, ok := t.scrollers[t.focus]
+	if !ok {
+		return 0
+	}
+	if h := scroller.Size().Y - 1; h > 1 {
+		return h
+	}
+	return 1
+}
+
+// scroll scrolls the focused pane by lines, which stops it from following
+// its output until it is scrolled back to the bottom.
+func (t *TUI) scroll(lines int) {
+	if t.focus < 0 {
+		return
+	}
+	top, ok := t.paused[t.focus]
+	if !ok {
+		top = t.bottom(t.focus)
+	}
+	t.scrollTo(t.focus, top+lines)
+}
+
+// bottom is how far a pane is scrolled when it shows the end of its
+// output.
+func (t *TUI) bottom(rank int) int {
+	bottom := t.clients[rank].SizeHint().Y - t.scrollers[rank].Size().Y
+	if bottom < 0 {
+		return 0
+	}
+	return bottom
+}
+
+// scrollTo scrolls a pane so that its line top is at the top.
+func (t *TUI) scrollTo(rank int, top int) {
+	scroller, ok := t.scrollers[rank]
+	if !ok {
+		return
+	}
+	if top < 0 {
+		top = 0
+	}
+	if top >= t.bottom(rank) {
+		t.follow(rank)
+		return
+	}
+	scroller.SetAutoscrollToBottom(false)
+	scroller.ScrollToTop()
+	scroller.Scroll(0, top)
+	t.paused[rank] = top
+	t.panes[rank].SetTitle(t.title(rank))
+}
+
+// follow scrolls a pane to the bottom, where it stays as output arrives.
+func (t *TUI) follow(rank int) {
+	scroller, ok := t.scrollers[rank]
+	if !ok {
+		return
+	}
+	scroller.SetAutoscrollToBottom(true)
+	scroller.ScrollToBottom()
+	delete(t.paused, rank)
+	t.panes[rank].SetTitle(t.title(rank))
+}
+
+// toggleMaximized shows only the pane of the focused rank, or all the panes
+// again if one is maximized. The input keeps Ctrl+F for itself.
+func (t *TUI) toggleMaximized() {
+	if t.focus == -1 {
+		return
+	}
+	if t.maximized >= 0 {
+		t.restore()
+	} else if t.focus >= 0 {
+		t.maximize(t.focus)
+	}
+}
+
+func (t *TUI) maximize(rank int) {
+	t.maximized = rank
+	t.arrange()
+}
+
+func (t *TUI) restore() {
+	t.maximized = -1
+	t.arrange()
+}
+
+func containsRank(ranks []int, rank int) bool {
+	for _, r := range ranks {
+		if r == rank {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,175 @@
+package tui
+
+import (
+	"fmt"
+	"image"
+
+	tui "github.com/marcusolsson/tui-go"
+)
+
+// The states a rank is shown in by the overview, in the order of the
+// legend. Each is drawn with the style "overview.<state>".
+var overviewStates = []string{"running", "stopped", "collective", "crashed", "exited", "unknown"}
+
+var overviewLegend = map[string]string{
+	"running":    "running",
+	"stopped":    "stopped",
+	"collective": "pending collective",
+	"crashed":    "crashed",
+	"exited":     "exited",
+	"unknown":    "not reported",
+}
+
+// setOverviewStyles sets the colors of the overview in a theme.
+func setOverviewStyles(theme *tui.Theme) {
+	theme.SetStyle("overview.running", tui.Style{Fg: tui.ColorBlack, Bg: tui.ColorGreen})
+	theme.SetStyle("overview.stopped", tui.Style{Fg: tui.ColorBlack, Bg: tui.ColorYellow})
+	theme.SetStyle("overview.collective", tui.Style{Fg: tui.ColorBlack, Bg: tui.ColorCyan})
+	theme.SetStyle("overview.crashed", tui.Style{Fg: tui.ColorWhite, Bg: tui.ColorRed})
+	theme.SetStyle("overview.exited", tui.Style{Fg: tui.ColorWhite, Bg: tui.ColorBlue})
+	theme.SetStyle("overview.unknown", tui.Style{})
+	theme.SetStyle("overview.cursor", tui.Style{Reverse: tui.DecorationOn, Bold: tui.DecorationOn})
+}
+
+// gridFocus is the focus while the overview has it, in place of a rank.
+const gridFocus = -2
+
+// setOverviewKeys sets the keys which select ranks in the overview, once
+// Ctrl+N or Ctrl+P gave it the focus:
+//
+//	arrows               move the cursor between the ranks
+//	Enter                open the pane of the rank under the cursor, or
+//	                     close it if it is open
+//
+// Up and Down move the cursor as well, in place of scrolling.
+func (t *TUI) setOverviewKeys() {
+	t.ui.SetKeybinding("Left", func() {
+		if t.focus == gridFocus {
+			t.moveCursor(-1)
+		}
+	})
+	t.ui.SetKeybinding("Right", func() {
+		if t.focus == gridFocus {
+			t.moveCursor(1)
+		}
+	})
+	t.ui.SetKeybinding("Enter", func() {
+		if t.focus == gridFocus {
+			t.togglePane(t.grid.cursor)
+		}
+	})
+}
+
+// moveCursor moves the cursor of the overview by step ranks, where a row
+// is as many as the columns of the grid. It stays put at the edges.
+func (t *TUI) moveCursor(step int) {
+	if cursor := t.grid.cursor + step; cursor >= 0 && cursor < t.grid.size {
+		t.grid.cursor = cursor
+	}
+	t.drawStatus()
+}
+
+// togglePane opens the pane of a rank, or closes it if it is open.
+func (t *TUI) togglePane(rank int) {
+	if _, ok := t.panes[rank]; ok {
+		t.Remove([]int{rank})
+	} else {
+		t.Add([]int{rank})
+	}
+}
+
+// overview is a grid with a cell for every rank, colored by its state,
+// followed by a legend. The ranks which have a pane are marked, and so is
+// the rank under the cursor while the overview has the focus.
+type overview struct {
+	tui.WidgetBase
+	size      int
+	states    map[int]string
+	shown     map[int]bool
+	cursor    int
+	selecting bool
+}
+
+func newOverview(size int) *overview {
+	o := &overview{
+		size:   size,
+		states: make(map[int]string),
+		shown:  make(map[int]bool),
+	}
+	o.SetSizePolicy(tui.Expanding, tui.Maximum)
+	return o
+}
+
+// cellWidth is the width of a cell, which fits the largest rank between
+// markers.
+func (o *overview) cellWidth() int {
+	return len(fmt.Sprint(o.size-1)) + 2
+}
+
+// columns tells how many cells fit in a row.
+func (o *overview) columns() int {
+	width := o.Size().X
+	if width == 0 {
+		// The widget is not laid out yet.
+		width = 80
+	}
+	if n := width / o.cellWidth(); n > 0 {
+		return n
+	}
+	return 1
+}
+
+func (o *overview) SizeHint() image.Point {
+	rows := (o.size + o.columns() - 1) / o.columns()
+	return image.Point{o.columns() * o.cellWidth(), rows + 1}
+}
+
+func (o *overview) MinSizeHint() image.Point {
+	return image.Point{o.cellWidth(), 2}
+}
+
+func (o *overview) Draw(p *tui.Painter) {
+	width := o.cellWidth()
+	columns := o.columns()
+	for rank := 0; rank < o.size; rank++ {
+		state, ok := o.states[rank]
+		if !ok {
+			state = "unknown"
+		}
+		cell := fmt.Sprintf(" %*d ", width-2, rank)
+		if o.shown[rank] {
+			cell = fmt.Sprintf("[%*d]", width-2, rank)
+		}
+		x, y := (rank%columns)*width, rank/columns
+		p.WithStyle("overview."+state, func(p *tui.Painter) {
+			if o.selecting && rank == o.cursor {
+				p.WithStyle("overview.cursor", func(p *tui.Painter) { p.DrawText(x, y, cell) })
+				return
+			}
+			p.DrawText(x, y, cell)
+		})
+	}
+
+	// The legend tells how many ranks are in every state.
+	counts := make(map[string]int)
+	for rank := 0; rank < o.size; rank++ {
+		state, ok := o.states[rank]
+		if !ok {
+			state = "unknown"
+		}
+		counts[state]++
+	}
+	x, y := 0, (o.size+columns-1)/columns
+	for _, state := range overviewStates {
+		if counts[state] == 0 {
+			continue
+		}
+		p.WithStyle("overview."+state, func(p *tui.Painter) {
+			p.DrawText(x, y, "  ")
+		})
+		text := fmt.Sprintf(" %s %d ", overviewLegend[state], counts[state])
+		p.DrawText(x+2, y, text)
+		x += 2 + len(text)
+	}
+	p.DrawText(x, y, "[n] has a pane")
+}
@@ -0,0 +1,275 @@
+package tui
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	tui "github.com/marcusolsson/tui-go"
+)
+
+// paneMatch is a message of a rank which matches the search, top rows
+// below the top of its pane. A rank without a pane has no label for it.
+type paneMatch struct {
+	rank  int
+	top   int
+	label *tui.Label
+}
+
+// setMatchStyles sets the colors of the messages which match the search.
+func setMatchStyles(theme *tui.Theme) {
+	theme.SetStyle("label.match", tui.Style{Fg: tui.ColorBlack, Bg: tui.ColorYellow})
+	theme.SetStyle("label.match.current", tui.Style{Fg: tui.ColorBlack, Bg: tui.ColorMagenta})
+}
+
+// setMatchKeys sets the keys which go through the matches of the search
+// while a pane has the focus:
+//
+//	n, p                 go to the next or previous match
+func (t *TUI) setMatchKeys() {
+	t.ui.SetKeybinding("n", func() {
+		if t.focus >= 0 {
+			t.jumpMatch(1)
+		}
+	})
+	t.ui.SetKeybinding("p", func() {
+		if t.focus >= 0 {
+			t.jumpMatch(-1)
+		}
+	})
+}
+
+// Search finds the messages of every rank which match re, highlights those
+// in the panes, and gives the focus to the pane of the first of them. The
+// pane of a rank without one is opened once n or p goes to its messages,
+// or if only ranks without a pane have any. A nil re ends the search. It
+// gives back how many messages match.
+func (t *TUI) Search(re *regexp.Regexp) int {
+	t.pattern = re
+	t.refillPanes()
+	if len(t.matches) != 0 {
+		for i, m := range t.matches {
+			if m.label != nil {
+				t.matchAt = i - 1
+				break
+			}
+		}
+		t.jumpMatch(1)
+	}
+	return len(t.matches)
+}
+
+// Filter shows only the lines of the panes which match re, or only those
+// which do not if out is true. A nil re shows all the lines again.
+func (t *TUI) Filter(re *regexp.Regexp, out bool) {
+	t.filter = re
+	t.filterOut = out
+	t.refillPanes()
+}
+
+// refillPanes puts the messages back into all the panes, and searches
+// those of the ranks without one, as the search or the filter changed.
+func (t *TUI) refillPanes() {
+	t.matches = nil
+	t.matchAt = -1
+	for _, rank := range t.shownRanks() {
+		t.fillPane(rank)
+		t.follow(rank)
+	}
+	t.searchHidden()
+}
+
+// searchHidden finds the matches of the search in the messages of the
+// ranks without a pane.
+func (t *TUI) searchHidden() {
+	if t.pattern == nil {
+		return
+	}
+	for rank := range t.history {
+		if _, ok := t.clients[rank]; !ok {
+			t.fillPane(rank)
+		}
+	}
+}
+
+// fillPane puts the messages of a rank which pass the filter into its
+// pane, or only finds those which match the search if it has none.
+func (t *TUI) fillPane(rank int) {
+	t.dropMatches(rank)
+	if box, ok := t.clients[rank]; ok {
+		for box.Length() != 0 {
+			box.Remove(0)
+		}
+		t.rows[rank] = 0
+		t.appendDroppedNote(rank)
+	} else if t.pattern == nil {
+		return
+	}
+	for _, message := range t.history[rank] {
+		t.appendLine(rank, message)
+	}
+}
+
+// appendLine adds a message to the pane of a rank, or only to the matches
+// if it matches the search and the rank has no pane. With a filter, only
+// the lines of the message which pass it are added.
+func (t *TUI) appendLine(rank int, message string) {
+	box, ok := t.clients[rank]
+	if !ok && t.pattern == nil {
+		return
+	}
+	if t.filter != nil {
+		var kept []string
+		for _, line := range strings.Split(message, "\n") {
+			if t.filter.MatchString(line) != t.filterOut {
+				kept = append(kept, line)
+			}
+		}
+		if len(kept) == 0 {
+			return
+		}
+		message = strings.Join(kept, "\n")
+	}
+	if !ok {
+		if t.pattern.MatchString(message) {
+			t.addMatch(paneMatch{rank: rank})
+		}
+		return
+	}
+
+	label := tui.NewLabel(message)
+	if t.pattern != nil && t.pattern.MatchString(message) {
+		label.SetStyleName("match")
+		t.addMatch(paneMatch{rank: rank, top: t.rows[rank], label: label})
+	}
+	box.Append(tui.NewHBox(
+		tui.NewPadder(1, 0, label),
+		tui.NewSpacer(),
+	))
+	t.rows[rank] += strings.Count(message, "\n") + 1
+}
+
+// addMatch keeps the matches in the order of the ranks, and of the
+// messages within a pane.
+func (t *TUI) addMatch(m paneMatch) {
+	i := sort.Search(len(t.matches), func(i int) bool {
+		return t.matches[i].rank > m.rank
+	})
+	t.matches = append(t.matches, paneMatch{})
+	copy(t.matches[i+1:], t.matches[i:])
+	t.matches[i] = m
+	if i <= t.matchAt {
+		t.matchAt++
+	}
+}
+
+// dropMatches forgets the matches in the pane of a rank.
+func (t *TUI) dropMatches(rank int) {
+	var kept []paneMatch
+	for i, m := range t.matches {
+		if m.rank != rank {
+			kept = append(kept, m)
+		} else if i == t.matchAt {
+			t.matchAt = -1
+		} else if i < t.matchAt {
+			t.matchAt--
+		}
+	}
+	t.matches = kept
+}
+
+// jumpMatch moves to the match step matches away, scrolling its pane so
+// that it is in the middle and giving that pane the focus. The pane is
+// opened if the rank has none.
+func (t *TUI) jumpMatch(step int) {
+	if len(t.matches) == 0 {
+		return
+	}
+	old := -1
+	if t.matchAt >= 0 {
+		old = t.matches[t.matchAt].rank
+		if label := t.matches[t.matchAt].label; label != nil {
+			label.SetStyleName("match")
+		}
+	}
+	if t.matchAt < 0 && step < 0 {
+		t.matchAt = 0
+	}
+	t.matchAt = (t.matchAt + step + len(t.matches)) % len(t.matches)
+	m := t.matches[t.matchAt]
+	if m.label == nil {
+		var ok bool
+		if m, ok = t.openMatch(t.matchAt); !ok {
+			return
+		}
+	}
+	m.label.SetStyleName("match.current")
+
+	t.setFocus(m.rank)
+	if t.maximized >= 0 && t.maximized != m.rank {
+		t.maximize(m.rank)
+	}
+	t.scrollTo(m.rank, m.top-t.scrollers[m.rank].Size().Y/2)
+	if pane, ok := t.panes[old]; ok {
+		pane.SetTitle(t.title(old))
+	}
+	t.panes[m.rank].SetTitle(t.title(m.rank))
+}
+
+// openMatch opens the pane of the rank of the match at i, which has none,
+// and gives back the match as found in it.
+func (t *TUI) openMatch(i int) (paneMatch, bool) {
+	rank, nth := t.matches[i].rank, 0
+	for _, m := range t.matches[:i] {
+		if m.rank == rank {
+			nth++
+		}
+	}
+	maximized := t.maximized
+	// Drawing the panes again finds the matches again.
+	t.Add([]int{rank})
+	if maximized >= 0 {
+		t.maximized = rank
+		t.arrange()
+	}
+	// The new pane is laid out, so that it can be scrolled to the match.
+	t.ui.Repaint()
+	for j, m := range t.matches {
+		if m.rank != rank {
+			continue
+		}
+		if nth == 0 && m.label != nil {
+			t.matchAt = j
+			return m, true
+		}
+		nth--
+	}
+	return paneMatch{}, false
+}
+
+// searchTitle tells in the title of a pane what is searched for and shown,
+// e.g. /MPI_Send 3 of 12.
+func (t *TUI) searchTitle(rank int) string {
+	title := ""
+	if t.filter != nil {
+		if t.filterOut {
+			title += fmt.Sprintf(" (without %s)", t.filter)
+		} else {
+			title += fmt.Sprintf(" (only %s)", t.filter)
+		}
+	}
+	if t.pattern != nil && t.matchAt >= 0 && t.matches[t.matchAt].rank == rank {
+		title += fmt.Sprintf(" /%s %d of %d, n/p for more", t.pattern, t.matchAt+1, len(t.matches))
+		hidden := 0
+		for _, m := range t.matches {
+			if m.label == nil {
+				hidden++
+			}
+		}
+		if hidden != 0 {
+			title += fmt.Sprintf(", %d in ranks without a pane", hidden)
+		}
+	}
+	return title
+}
@@ -0,0 +1,94 @@
+package tui
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	tui "github.com/marcusolsson/tui-go"
+)
+
+// maxEvents is how many events are kept, and maxEventRows how many of the
+// latest are shown.
+const (
+	maxEvents    = 500
+	maxEventRows = 5
+)
+
+// setStatusStyles sets the styles of the status bar, and of the tabs of
+// the tabbed layout.
+func setStatusStyles(theme *tui.Theme) {
+	theme.SetStyle("label.status", tui.Style{Reverse: tui.DecorationOn})
+	theme.SetStyle("label.tabs", tui.Style{Bold: tui.DecorationOn})
+}
+
+// newEventsPane makes the pane which shows the latest events, and the
+// status bar below it.
+func newEventsPane() (events *tui.Box, pane *tui.Box, status *tui.Label) {
+	events = tui.NewVBox()
+	pane = tui.NewVBox(events, tui.NewSpacer())
+	pane.SetBorder(true)
+	pane.SetTitle("events")
+	pane.SetSizePolicy(tui.Expanding, tui.Maximum)
+	status = tui.NewLabel("")
+	status.SetStyleName("status")
+	status.SetSizePolicy(tui.Expanding, tui.Maximum)
+	return
+}
+
+// SetStatus sets what the status bar tells about the session. The panes
+// which are shown, and the one which has the focus, are added to it.
+func (t *TUI) SetStatus(text string) {
+	t.ui.Update(func() {
+		t.statusText = text
+		t.drawStatus()
+	})
+}
+
+func (t *TUI) drawStatus() {
+	var panes []string
+	for _, rank := range t.shownRanks() {
+		panes = append(panes, strconv.Itoa(rank))
+	}
+	text := t.statusText + " │ " + t.layoutName() + " panes " + strings.Join(panes, ",")
+	switch {
+	case t.focus >= 0:
+		text += fmt.Sprintf(", focus %d", t.focus)
+	case t.focus == gridFocus:
+		text += fmt.Sprintf(", rank %d selected", t.grid.cursor)
+	}
+	t.statusBar.SetText(" " + text)
+}
+
+// LogEvent adds an event which involves one or more ranks to the events
+// pane, with the time it happened.
+func (t *TUI) LogEvent(text string) {
+	at := time.Now()
+	t.ui.Update(func() {
+		event := at.Format("15:04:05") + " " + text
+		t.eventLog = append(t.eventLog, event)
+		if len(t.eventLog) > maxEvents {
+			t.eventLog = t.eventLog[len(t.eventLog)-maxEvents:]
+		}
+		t.events.Append(tui.NewHBox(
+			tui.NewPadder(1, 0, tui.NewLabel(event)),
+			tui.NewSpacer(),
+		))
+		for t.events.Length() > maxEventRows {
+			t.events.Remove(0)
+		}
+		t.eventsPane.SetTitle(fmt.Sprintf("events (%d, pdb_events for all)", len(t.eventLog)))
+	})
+}
+
+// ShowEvents shows all the events which were kept in the panes.
+func (t *TUI) ShowEvents() {
+	t.ui.Update(func() {
+		text := "No events yet"
+		if len(t.eventLog) != 0 {
+			text = "Events:\n" + strings.Join(t.eventLog, "\n")
+		}
+		t.showUserInputAll(text)
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"regexp"
 	"sort"
 
 	tui "github.com/marcusolsson/tui-go"
@@ -13,50 +14,167 @@ type TUI struct {
 	root         *tui.Box
 	ui           tui.UI
 	clients      map[int]*tui.Box
+	panes        map[int]*tui.Box
+	status       map[int]string
 	Input        *tui.Entry
 	clientParent *tui.Box
 	conn         map[int]*net.Conn
-	numOfClients int
 	history      map[int][]string
 	cmdHistory   []string
 	histPtr      int
+	quitHooks    []func()
+	// source holds the lines shown in sourcePane, which is at the top of
+	// root while it is shown.
+	source      *tui.Box
+	sourcePane  *tui.Box
+	sourceShown bool
+	// grid gives an overview of all the ranks, above their panes.
+	grid     *overview
+	gridPane *tui.Box
+	// scrollers holds the scroll area of every pane, and paused how far
+	// down the panes which are not following their output are scrolled.
+	scrollers map[int]*tui.ScrollArea
+	paused    map[int]int
+	// focus is the rank whose pane has the focus, or -1 when the input
+	// has it, and maximized the rank whose pane is the only one shown, or
+	// -1.
+	focus      int
+	maximized  int
+	focusHooks []func(rank int)
+	// completions shows the candidates for completing the input, right
+	// above it.
+	completer        func(input string) (string, []string)
+	completions      *tui.Label
+	completionsPane  *tui.Box
+	completionsShown bool
+	// searching is true during a reverse search of cmdHistory, which has
+	// found the command at searchMatch, and searchSaved is the input from
+	// before it.
+	searching   bool
+	searchMatch int
+	searchSaved string
+	// pattern is what the panes are searched for, and matches the messages
+	// which match it, of which the one at matchAt was jumped to last.
+	// Only the lines which match filter, or which do not if filterOut is
+	// true, are shown. rows is how many rows the messages in every pane
+	// take.
+	pattern   *regexp.Regexp
+	matches   []paneMatch
+	matchAt   int
+	filter    *regexp.Regexp
+	filterOut bool
+	rows      map[int]int
+	// limit is how many messages of every rank are kept, or 0 for no
+	// limit, and dropped how many older ones were dropped. spillFile
+	// names the file in which the output of a rank is kept in full, if
+	// any.
+	limit     int
+	dropped   map[int]int
+	spillFile func(rank int) string
+	// events shows the latest of the events in eventLog, and statusBar
+	// what statusText tells about the session, below it.
+	events     *tui.Box
+	eventsPane *tui.Box
+	eventLog   []string
+	statusBar  *tui.Label
+	statusText string
+	// layout is how the panes are arranged, see Layouts, gridRows and
+	// gridCols the size of the grid, and current the rank whose pane the
+	// tabbed layout shows.
+	layout      string
+	gridRows    int
+	gridCols    int
+	current     int
+	layoutHooks []func(l Layout)
+	// diffPane shows a diff of two ranks in place of the panes, with
+	// diffRows scrolled by diffTop rows in diffScroller.
+	diffPane     *tui.Box
+	diffScroller *tui.ScrollArea
+	diffRows     []DiffRow
+	diffTop      int
 }
 
-// NewTUI creates a new instance of a TUI
-// it initializes the number of clients to be displayed to be as 2
+// startPanes is how many ranks get a pane when the UI is drawn, unless the
+// world is smaller. A saved layout is restored with RestoreLayout instead.
+const startPanes = 4
+
+// NewTUI creates a new instance of a TUI for the ranks in connections.
 func NewTUI(connections map[int]*net.Conn) (t *TUI) {
 	t = new(TUI)
 	t.clients = make(map[int]*tui.Box)
-	t.clientParent = tui.NewHBox()
+	t.panes = make(map[int]*tui.Box)
+	t.status = make(map[int]string)
+	t.scrollers = make(map[int]*tui.ScrollArea)
+	t.paused = make(map[int]int)
+	t.rows = make(map[int]int)
+	t.dropped = make(map[int]int)
+	t.matchAt = -1
+	t.focus = -1
+	t.maximized = -1
+	t.clientParent = tui.NewVBox()
+	t.layout = "horizontal"
 	t.Input = tui.NewEntry()
 	t.Input.SetFocused(true)
 	t.Input.SetSizePolicy(tui.Expanding, tui.Maximum)
+	t.Input.OnChanged(func(*tui.Entry) {
+		if t.searching {
+			t.search(len(t.cmdHistory)-1, false)
+			return
+		}
+		t.hideCompletions()
+	})
+	t.completions, t.completionsPane = newCompletionsPane()
+	t.events, t.eventsPane, t.statusBar = newEventsPane()
 	t.root = tui.NewVBox()
 	t.conn = connections
-	t.numOfClients = 2
 	t.history = make(map[int][]string)
 	t.histPtr = 0
+	t.source = tui.NewVBox()
+	t.sourcePane = tui.NewVBox(t.source)
+	t.sourcePane.SetBorder(true)
+	t.sourcePane.SetSizePolicy(tui.Expanding, tui.Maximum)
+	t.grid = newOverview(len(connections))
+	t.gridPane = tui.NewVBox(t.grid)
+	t.gridPane.SetBorder(true)
+	t.gridPane.SetTitle("ranks")
+	t.gridPane.SetSizePolicy(tui.Expanding, tui.Maximum)
 	return
 }
 
+// title gives the title of a rank's pane, including its status if known,
+// e.g. rank-3 [stopped foo.c:88 bkpt 2]. The focused pane is marked, and so
+// is a pane which is scrolled back.
+func (t *TUI) title(rank int) string {
+	title := fmt.Sprintf("rank-%d", rank)
+	if status, ok := t.status[rank]; ok {
+		title += fmt.Sprintf(" [%s]", status)
+	}
+	if _, ok := t.paused[rank]; ok {
+		title += " (scrolled, End to follow)"
+	}
+	title += t.searchTitle(rank)
+	if rank == t.focus {
+		title = "> " + title
+	}
+	return title
+}
+
 func (t *TUI) drawClient(title string, rank int) *tui.Box {
 	box := tui.NewVBox()
-
+	t.clients[rank] = box
 	// if history exists
-	for _, hist := range t.history[rank] {
-		histBox := tui.NewHBox(
-			tui.NewPadder(1, 0, tui.NewLabel(hist)),
-			tui.NewSpacer(),
-		)
-		box.Append(histBox)
-	}
+	t.fillPane(rank)
 
 	scroller := tui.NewScrollArea(box)
 	scroller.SetAutoscrollToBottom(true)
 	scrollerBox := tui.NewVBox(scroller)
 	scrollerBox.SetBorder(true)
 	scrollerBox.SetTitle(title)
-	t.clients[rank] = box
+	// Panes share the space equally with the empty cells of a grid.
+	scrollerBox.SetSizePolicy(tui.Expanding, tui.Expanding)
+	t.panes[rank] = scrollerBox
+	t.scrollers[rank] = scroller
+	t.grid.shown[rank] = true
 	return scrollerBox
 }
 
@@ -69,22 +187,52 @@ func (t *TUI) drawInput() {
 	t.root.Append(t.Input)
 }
 
-// DrawUI paints the complete UI along with the clients and inputBox
+// DrawUI paints the complete UI along with the clients and inputBox. The
+// lowest ranks get a pane, side by side if they are two at most and in a
+// grid otherwise.
 func (t *TUI) DrawUI() {
-	for i := 0; i < t.numOfClients; i++ {
-		box := t.drawClient(fmt.Sprintf("rank-%d", i), i)
-		t.clientParent.Append(box)
+	var ranks []int
+	for rank := range t.conn {
+		ranks = append(ranks, rank)
+	}
+	sort.Ints(ranks)
+	if len(ranks) > startPanes {
+		ranks = ranks[:startPanes]
+	}
+	if len(ranks) > 2 {
+		t.layout = "grid"
 	}
+	t.drawClients(ranks)
+	t.root.Append(t.gridPane)
 	t.root.Append(t.clientParent)
+	t.root.Append(t.eventsPane)
+	t.root.Append(t.statusBar)
 	t.drawInput()
 	var err error
 	t.ui, err = tui.New(t.root)
 	if err != nil {
 		panic(err)
 	}
+	theme := tui.NewTheme()
+	setOverviewStyles(theme)
+	setMatchStyles(theme)
+	setStatusStyles(theme)
+	setDiffStyles(theme)
+	t.ui.SetTheme(theme)
 
-	// TODO command History
 	t.ui.SetKeybinding("Up", func() {
+		if t.focus == gridFocus {
+			t.moveCursor(-t.grid.columns())
+			return
+		}
+		if t.focus >= 0 {
+			t.scroll(-1)
+			return
+		}
+		if t.searching {
+			t.endSearch(true)
+			return
+		}
 		if len(t.cmdHistory) == 0 {
 			return
 		}
@@ -100,6 +248,18 @@ func (t *TUI) DrawUI() {
 	})
 
 	t.ui.SetKeybinding("Down", func() {
+		if t.focus == gridFocus {
+			t.moveCursor(t.grid.columns())
+			return
+		}
+		if t.focus >= 0 {
+			t.scroll(1)
+			return
+		}
+		if t.searching {
+			t.endSearch(true)
+			return
+		}
 		if len(t.cmdHistory) == 0 || t.histPtr == len(t.cmdHistory) {
 			return
 		}
@@ -115,6 +275,14 @@ func (t *TUI) DrawUI() {
 		}
 		t.Input.SetText(t.cmdHistory[t.histPtr])
 	})
+	t.setNavigationKeys()
+	t.setOverviewKeys()
+	// The diff closes on Esc only if it does not end a search, so its keys
+	// go first.
+	t.setDiffKeys()
+	t.setSearchKeys()
+	t.setMatchKeys()
+	t.ui.SetKeybinding("Tab", t.complete)
 
 	go func() {
 		err := t.ui.Run()
@@ -124,102 +292,104 @@ func (t *TUI) DrawUI() {
 	}()
 }
 
+// AddToCmdHistory adds input which was submitted to the history, which is
+// also when the candidates for completing it are no longer needed.
 func (t *TUI) AddToCmdHistory(hist string) {
+	t.searching = false
+	t.hideCompletions()
 	t.cmdHistory = append(t.cmdHistory, hist)
 	t.histPtr = len(t.cmdHistory)
 }
 
-func (t *TUI) reDraw(rank int, cat string) {
-
-	var currClients []int
+// reDraw adds the panes of ranks, or removes them if cat is "Remove". The
+// panes are only drawn again if that changes which ranks have one.
+func (t *TUI) reDraw(ranks []int, cat string) {
+	shown := make(map[int]bool)
 	for r := range t.clients {
-		currClients = append(currClients, r)
+		shown[r] = true
 	}
-	t.clients = make(map[int]*tui.Box)
-	for t.clientParent.Length() != 0 {
-		t.clientParent.Remove(0)
-	}
-	if cat == "Add" {
-		currClients = append(currClients, rank)
-	} else if cat == "Remove" {
-		i := -1
-		for idx, r := range currClients {
-			if r == rank {
-				i = idx
-				break
-			}
-		}
-		if i != -1 {
-			currClients = append(currClients[:i], currClients[i+1:]...)
+	changed := false
+	for _, rank := range ranks {
+		if _, ok := t.conn[rank]; !ok || shown[rank] == (cat == "Add") {
+			continue
 		}
+		shown[rank] = cat == "Add"
+		changed = true
 	}
-	t.numOfClients = len(currClients)
-	sort.Ints(currClients)
-	for _, i := range currClients {
-		box := t.drawClient(fmt.Sprintf("rank-%d", i), i)
-		t.clientParent.Append(box)
+	if !changed {
+		return
 	}
 
+	var currClients []int
+	for r, ok := range shown {
+		if ok {
+			currClients = append(currClients, r)
+		}
+	}
+	t.drawClients(currClients)
 }
 
-func (t *TUI) Add(rank int) {
-	// if the box exists dont redraw
-	if _, ok := t.clients[rank]; ok {
-		return
+// drawClients replaces the panes with those of ranks.
+func (t *TUI) drawClients(ranks []int) {
+	t.clients = make(map[int]*tui.Box)
+	t.panes = make(map[int]*tui.Box)
+	t.scrollers = make(map[int]*tui.ScrollArea)
+	t.paused = make(map[int]int)
+	t.matches = nil
+	t.matchAt = -1
+	t.grid.shown = make(map[int]bool)
+	t.maximized = -1
+	if t.focus >= 0 && !containsRank(ranks, t.focus) {
+		t.setFocus(-1)
 	}
-	t.reDraw(rank, "Add")
+	sort.Ints(ranks)
+	for _, i := range ranks {
+		t.drawClient(t.title(i), i)
+	}
+	t.searchHidden()
+	t.arrange()
 }
 
-func (t *TUI) Remove(rank int) {
-	// if the box dont exists dont redraw
-	if _, ok := t.clients[rank]; !ok {
-		return
+// Show shows the panes of ranks in place of those shown now.
+func (t *TUI) Show(ranks []int) {
+	var shown []int
+	for _, rank := range ranks {
+		if _, ok := t.conn[rank]; ok {
+			shown = append(shown, rank)
+		}
 	}
-	t.reDraw(rank, "Remove")
+	t.drawClients(shown)
+}
+
+// Add adds the panes of ranks to those shown.
+func (t *TUI) Add(ranks []int) {
+	t.reDraw(ranks, "Add")
+}
+
+// Remove removes the panes of ranks.
+func (t *TUI) Remove(ranks []int) {
+	t.reDraw(ranks, "Remove")
 }
 
-func (t *TUI) Swap(newRank int, oldRank int) {
-	t.Remove(oldRank)
-	t.Add(newRank)
+// Swap shows the panes of newRanks in place of those of oldRanks.
+func (t *TUI) Swap(newRanks []int, oldRanks []int) {
+	t.Remove(oldRanks)
+	t.Add(newRanks)
 }
 
 // ShowMessagesAll displays a particular message
 // to all the clients in display
 func (t *TUI) ShowMessagesAll(message string) {
 	t.ui.Update(func() {
-		command := tui.NewHBox(
-			tui.NewPadder(1, 0, tui.NewLabel(message)),
-			tui.NewSpacer(),
-		)
-
-		var rank int
-		for rank = range t.conn {
-			t.history[rank] = append(t.history[rank], message)
-		}
-
-		var b *tui.Box
-		for rank, b = range t.clients {
-			b.Append(command)
-		}
+		t.showUserInputAll(message)
 	})
 }
 
 // ShowUserInputAll displays user input
 // to all the clients in display
 func (t *TUI) showUserInputAll(message string) {
-	command := tui.NewHBox(
-		tui.NewPadder(1, 0, tui.NewLabel(message)),
-		tui.NewSpacer(),
-	)
-
-	var rank int
-	for rank = range t.conn {
-		t.history[rank] = append(t.history[rank], message)
-	}
-
-	var b *tui.Box
-	for rank, b = range t.clients {
-		b.Append(command)
+	for rank := range t.conn {
+		t.addMessage(rank, message)
 	}
 }
 
@@ -231,19 +401,7 @@ func (t *TUI) ShowUserInputClients(message string, ranks []int) {
 	}
 
 	for _, rank := range ranks {
-		command := tui.NewHBox(
-			tui.NewPadder(1, 0, tui.NewLabel(message)),
-			tui.NewSpacer(),
-		)
-
-		t.history[rank] = append(t.history[rank], message)
-
-		var c *tui.Box
-		var ok bool
-		if c, ok = t.clients[rank]; !ok {
-			continue
-		}
-		c.Append(command)
+		t.addMessage(rank, message)
 	}
 }
 
@@ -252,24 +410,105 @@ func (t *TUI) ShowUserInputClients(message string, ranks []int) {
 // the function return void silently
 func (t *TUI) ShowMessagesClient(message string, rank int) {
 	t.ui.Update(func() {
-		command := tui.NewHBox(
-			tui.NewPadder(1, 0, tui.NewLabel(message)),
-			tui.NewSpacer(),
-		)
+		t.addMessage(rank, message)
+	})
 
-		t.history[rank] = append(t.history[rank], message)
+}
 
-		var c *tui.Box
-		var ok bool
-		if c, ok = t.clients[rank]; !ok {
-			return
+// SetRankStatus sets the status shown in the title of a rank's pane.
+func (t *TUI) SetRankStatus(rank int, status string) {
+	t.ui.Update(func() {
+		t.status[rank] = status
+		if pane, ok := t.panes[rank]; ok {
+			pane.SetTitle(t.title(rank))
+		}
+	})
+}
+
+// ShowSource shows lines of source in the source pane, under a title.
+func (t *TUI) ShowSource(title string, lines []string) {
+	t.ui.Update(func() {
+		for t.source.Length() != 0 {
+			t.source.Remove(0)
+		}
+		for _, line := range lines {
+			t.source.Append(tui.NewHBox(
+				tui.NewPadder(1, 0, tui.NewLabel(line)),
+				tui.NewSpacer(),
+			))
+		}
+		t.sourcePane.SetTitle(title)
+		if !t.sourceShown {
+			t.root.Prepend(t.sourcePane)
+			t.sourceShown = true
+		}
+	})
+}
+
+// HideSource hides the source pane.
+func (t *TUI) HideSource() {
+	t.ui.Update(func() {
+		if t.sourceShown {
+			t.root.Remove(0)
+			t.sourceShown = false
 		}
-		c.Append(command)
 	})
+}
 
+// SetRankState sets the state a rank is shown in by the overview, which is
+// one of running, stopped, collective, crashed and exited.
+func (t *TUI) SetRankState(rank int, state string) {
+	t.ui.Update(func() {
+		t.grid.states[rank] = state
+	})
+}
+
+// Clear empties the panes of all the ranks and the events pane, and forgets
+// the statuses of the ranks.
+func (t *TUI) Clear() {
+	t.ui.Update(func() {
+		t.history = make(map[int][]string)
+		t.dropped = make(map[int]int)
+		t.eventLog = nil
+		for t.events.Length() != 0 {
+			t.events.Remove(0)
+		}
+		t.eventsPane.SetTitle("events")
+		t.status = make(map[int]string)
+		t.grid.size = len(t.conn)
+		t.grid.states = make(map[int]string)
+		for rank := range t.paused {
+			t.follow(rank)
+		}
+		t.matches = nil
+		t.matchAt = -1
+		for rank, box := range t.clients {
+			for box.Length() != 0 {
+				box.Remove(0)
+			}
+			t.rows[rank] = 0
+		}
+		for rank, pane := range t.panes {
+			pane.SetTitle(t.title(rank))
+		}
+	})
+}
+
+// Update runs fn on the UI goroutine, for the functions which must not be
+// called from anywhere else, e.g. ShowUserInputClients.
+func (t *TUI) Update(fn func()) {
+	t.ui.Update(fn)
+}
+
+// OnQuit adds a function to be run when the user quits.
+func (t *TUI) OnQuit(fn func()) {
+	t.quitHooks = append(t.quitHooks, fn)
 }
 
 func (t *TUI) Quit() {
 	t.ui.Quit()
+	for _, fn := range t.quitHooks {
+		fn()
+	}
 	os.Exit(0)
 }
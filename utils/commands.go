@@ -0,0 +1,213 @@
+package utils
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Request is something the server asks of a client, which is answered with
+// a Response carrying the same ID.
+type Request struct {
+	ID   int
+	Op   string
+	Args []string
+}
+
+// Response is the answer of a client to a Request. Result holds the JSON
+// encoding of whatever the request gives back, unless it failed with Error.
+type Response struct {
+	ID     int
+	Result json.RawMessage `json:",omitempty"`
+	Error  string          `json:",omitempty"`
+}
+
+// Flow tells whether the console output of the program is sent to the
+// server, which pauses it while the rank prints faster than it can be shown.
+type Flow struct {
+	paused bool
+	held   int
+	mux    sync.Mutex
+}
+
+// Send tells whether a line of output is to be sent, and counts it as held
+// back if not.
+func (f *Flow) Send() bool {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if f.paused {
+		f.held++
+	}
+	return !f.paused
+}
+
+// setPaused pauses or resumes the output, and gives back how many lines
+// were held back since it was paused.
+func (f *Flow) setPaused(paused bool) (held int) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	held = f.held
+	f.paused = paused
+	f.held = 0
+	return
+}
+
+// maxMessageSize is the longest message sent between the server and a
+// client. A source file of up to maxSourceSize is sent in a single line,
+// which escaping it may make several times longer.
+const maxMessageSize = 8 * maxSourceSize
+
+// NewMessageScanner reads the messages sent between the server and a
+// client, one per line.
+func NewMessageScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), maxMessageSize)
+	return scanner
+}
+
+// This will run indefinitely and process messages from some ReadWriter.
+// This will (usually) be the Conn of the server, to which the responses to
+// requests are written.
+// For each message, it either runs it in the debugger (if the message prefix is RUN:)
+// else it prints the message (if the prefix is COMMAND:)
+// Messages are processed one at a time in a separate goroutine, except for
+// INTERRUPT and FLOW which are acted upon immediately, since the goroutine
+// may be blocked waiting for the inferior to stop. FLOW:pause stops the
+// output from being sent until FLOW:resume, which is answered with
+// HELD:<lines> telling how many lines were held back.
+func ProcessCommands(d Debugger, rw io.ReadWriter, flow *Flow, processCommandsDone chan bool) {
+	scanner := NewMessageScanner(rw)
+	messages := make(chan []string, 64)
+
+	go func() {
+		for lineSplit := range messages {
+			processMessage(d, lineSplit, rw)
+		}
+	}()
+
+	for scanner.Scan() {
+		CheckError(scanner.Err())
+		line := scanner.Text()
+		lineSplit := strings.SplitN(line, ":", 2)
+
+		if len(lineSplit) != 2 {
+			continue
+		}
+
+		if lineSplit[0] == "INTERRUPT" {
+			d.Interrupt()
+			continue
+		}
+		if lineSplit[0] == "FLOW" {
+			if flow != nil {
+				held := flow.setPaused(lineSplit[1] == "pause")
+				if lineSplit[1] == "resume" {
+					fmt.Fprintf(rw, "HELD:%d\n", held)
+				}
+			}
+			continue
+		}
+		messages <- lineSplit
+	}
+
+	close(messages)
+	processCommandsDone <- true
+}
+
+func processMessage(d Debugger, lineSplit []string, w io.Writer) {
+	if lineSplit[0] == "COMMAND" {
+		fmt.Printf("Server message: %s\n", lineSplit[1])
+	} else if lineSplit[0] == "RUN" {
+		fmt.Printf("Running: %s\n", lineSplit[1])
+		d.Execute(lineSplit[1])
+	} else if lineSplit[0] == "COLLECTIVE" {
+		d.ToggleCollectiveTracking(lineSplit[1])
+	} else if lineSplit[0] == "REQUEST" {
+		var req Request
+		if err := json.Unmarshal([]byte(lineSplit[1]), &req); err != nil {
+			return
+		}
+		resp := Response{ID: req.ID}
+		result, err := handleRequest(d, req)
+		if err == nil {
+			resp.Result, err = json.Marshal(result)
+		}
+		if err != nil {
+			resp.Error = err.Error()
+		}
+		out, _ := json.Marshal(resp)
+		fmt.Fprintf(w, "RESPONSE:%s\n", out)
+	}
+}
+
+// handleRequest does what a request asks for, and gives back its result.
+func handleRequest(d Debugger, req Request) (interface{}, error) {
+	arg := func(i int) string {
+		if i < len(req.Args) {
+			return req.Args[i]
+		}
+		return ""
+	}
+
+	switch req.Op {
+	case "blocking-call":
+		return BlockingCall(d)
+	case "advance-to":
+		return AdvanceTo(d, req.Args)
+	case "run-to-collective":
+		return d.RunToCollective(arg(0))
+	case "backtrace":
+		return d.Stack()
+	case "locals":
+		return d.Locals()
+	case "evaluate":
+		return d.Evaluate(arg(0))
+	case "source":
+		return ReadSource(arg(0))
+	case "symbols":
+		return d.Symbols()
+	case "source-files":
+		return d.SourceFiles()
+	case "break-insert":
+		return d.InsertBreakpoint(arg(0))
+	case "break-delete", "break-enable", "break-disable":
+		number, err := strconv.Atoi(arg(0))
+		if err != nil {
+			return nil, fmt.Errorf("bad breakpoint number %q", arg(0))
+		}
+		switch req.Op {
+		case "break-delete":
+			return nil, d.DeleteBreakpoint(number)
+		case "break-enable":
+			return nil, d.EnableBreakpoint(number, true)
+		}
+		return nil, d.EnableBreakpoint(number, false)
+	}
+	return nil, fmt.Errorf("unknown request %s", req.Op)
+}
+
+// maxSourceSize is the size of the largest source file sent to the server.
+const maxSourceSize = 4 << 20
+
+// ReadSource reads the lines of a source file, for the server which may not
+// have the sources.
+func ReadSource(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > maxSourceSize {
+		return nil, fmt.Errorf("%s is too large to show", path)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n"), nil
+}
@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// processLines runs ProcessCommands over input, and gives back what it
+// wrote.
+func processLines(flow *Flow, input string) string {
+	var out bytes.Buffer
+	rw := struct {
+		io.Reader
+		io.Writer
+	}{strings.NewReader(input), &out}
+	done := make(chan bool, 1)
+	ProcessCommands(nil, rw, flow, done)
+	return out.String()
+}
+
+func TestFlow(t *testing.T) {
+	var flow Flow
+	if !flow.Send() {
+		t.Fatal("output is held back before it is paused")
+	}
+
+	processLines(&flow, "FLOW:pause\n")
+	for i := 0; i < 3; i++ {
+		if flow.Send() {
+			t.Fatal("output is sent while it is paused")
+		}
+	}
+
+	if got := processLines(&flow, "FLOW:resume\n"); got != "HELD:3\n" {
+		t.Errorf("got %q on resuming, want %q", got, "HELD:3\n")
+	}
+	if !flow.Send() {
+		t.Error("output is held back after it is resumed")
+	}
+}
+
+func TestLargeSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// Far more than the 64 KiB a line may have by default, with quotes and
+	// tabs which get escaped.
+	var source strings.Builder
+	for i := 0; source.Len() < 256<<10; i++ {
+		fmt.Fprintf(&source, "\tprintf(\"line %d\\n\");\n", i)
+	}
+	path := filepath.Join(dir, "large.c")
+	if err := ioutil.WriteFile(path, []byte(source.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	lines, err := ReadSource(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, _ := json.Marshal(lines)
+	out, _ := json.Marshal(Response{ID: 1, Result: result})
+	scanner := NewMessageScanner(strings.NewReader("RESPONSE:" + string(out) + "\nCONSOLE:after\n"))
+
+	if !scanner.Scan() {
+		t.Fatalf("could not read the response: %v", scanner.Err())
+	}
+	var resp Response
+	var got []string
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(scanner.Text(), "RESPONSE:")), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(resp.Result, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, lines) {
+		t.Errorf("got %d lines back, want %d", len(got), len(lines))
+	}
+	if !scanner.Scan() || scanner.Text() != "CONSOLE:after" {
+		t.Errorf("the message after the response was not read: %v", scanner.Err())
+	}
+}
@@ -0,0 +1,138 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Debugger drives the program of one rank on behalf of pd-client.
+// GdbInstance, which talks to gdb over the MI interface, is the only
+// backend so far.
+type Debugger interface {
+	// RunToInit, LoadCore or Attach starts debugging a program.
+	// RunToInit runs the program with the given arguments until MPI is
+	// initialized, and gives back its rank and world size.
+	RunToInit(debugTarget string, args []string) (rank int, wSize int, err error)
+	// LoadCore loads a core file left behind by the program.
+	LoadCore(debugTarget string, coreFile string) error
+	// Attach attaches to a running process, which is left stopped.
+	Attach(pid int) error
+	// EvaluateRank asks MPI in the program for its rank and world size.
+	EvaluateRank() (rank int, wSize int, err error)
+
+	// ReportState starts reporting execution state changes, beginning with
+	// where the program is right now.
+	ReportState()
+
+	// Execute runs a debugger command and waits for it to complete. For a
+	// command which resumes the program, it waits until it stops again.
+	Execute(command string) error
+	// Evaluate evaluates an expression in the current frame.
+	Evaluate(expression string) (string, error)
+	// Stack gives the frames of the current thread, innermost first.
+	Stack() ([]Frame, error)
+	// Locals gives the arguments and local variables of the current frame,
+	// with their values.
+	Locals() ([]Variable, error)
+	// Interrupt stops the program if it is running.
+	Interrupt()
+	// Symbols gives the names of the functions and variables of the
+	// program, and SourceFiles its source files.
+	Symbols() ([]string, error)
+	SourceFiles() ([]string, error)
+
+	InsertBreakpoint(location string) (Breakpoint, error)
+	DeleteBreakpoint(number int) error
+	EnableBreakpoint(number int, enable bool) error
+
+	// ToggleCollectiveTracking starts or stops reporting the calls to a
+	// collective function.
+	ToggleCollectiveTracking(coll string)
+	// RunToCollective resumes the program until it calls a tracked
+	// collective, or the collective coll if it is not empty, and leaves it
+	// stopped there.
+	RunToCollective(coll string) (CollectiveInfo, error)
+
+	// AddEventHook adds a named hook which is run for every event.
+	AddEventHook(hookName string, hook func(e Event))
+	RemoveEventHook(hookName string)
+}
+
+// NewDebugger starts a debugger using the named backend.
+// Collective calls are reported on cInfoChan and execution state changes
+// on sInfoChan. For the gdb backend, PD_GDB may hold the command to run in
+// place of gdb.
+func NewDebugger(backend string, cInfoChan chan CollectiveInfo, sInfoChan chan StateInfo) (Debugger, error) {
+	switch backend {
+	case "", "gdb":
+		if cmd := strings.Fields(os.Getenv("PD_GDB")); len(cmd) != 0 {
+			return NewGdbCmd(cmd, cInfoChan, sInfoChan)
+		}
+		return NewGdb(cInfoChan, sInfoChan)
+	}
+	return nil, fmt.Errorf("unknown debugger backend %s", backend)
+}
+
+type EventKind int
+
+const (
+	// ConsoleEvent carries text printed by the debugger in Text.
+	ConsoleEvent EventKind = iota
+	// ErrorEvent carries the message of a failed command in Text.
+	ErrorEvent
+	// RunningEvent tells that the program was resumed.
+	RunningEvent
+	// StoppedEvent tells that the program stopped, with details in State.
+	StoppedEvent
+	// BreakpointEvent tells that the debugger changed a breakpoint, e.g.
+	// set a pending one once its library was loaded, as in Breakpoint.
+	BreakpointEvent
+	// OtherEvent is anything the backend reports which pd does not use.
+	OtherEvent
+)
+
+// Event is something reported by a debugger backend.
+type Event struct {
+	Kind       EventKind
+	Text       string
+	State      *StateInfo
+	Breakpoint *Breakpoint
+	// Record is what the backend reported, for logging.
+	Record interface{}
+}
+
+// Frame is a frame of the stack of the program.
+type Frame struct {
+	Level    int
+	Function string
+	File     string
+	Line     string
+	// Fullname is the absolute path of File.
+	Fullname string
+}
+
+func (f Frame) String() string {
+	if f.File == "" {
+		return f.Function
+	}
+	return fmt.Sprintf("%s at %s:%s", f.Function, f.File, f.Line)
+}
+
+// Variable is a variable of the program and its value, as the debugger
+// prints it.
+type Variable struct {
+	Name  string
+	Value string
+}
+
+// Breakpoint is a breakpoint as the debugger knows it.
+type Breakpoint struct {
+	Number   int
+	Function string
+	File     string
+	Line     string
+	Pending  bool
+	Enabled  bool
+	Hits     int
+}
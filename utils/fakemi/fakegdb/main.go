@@ -0,0 +1,14 @@
+// fakegdb replays a fakemi script in place of gdb, e.g.
+//
+//	PD_GDB="fakegdb session.mi" pd-client localhost:8080 ./a.out
+package main
+
+import (
+	"os"
+
+	"git.cse.iitk.ac.in/ssaha/parallel-debugger/utils/fakemi"
+)
+
+func main() {
+	os.Exit(fakemi.Main(os.Args[1:]))
+}
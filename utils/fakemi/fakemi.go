@@ -0,0 +1,182 @@
+// Package fakemi is a scriptable stand-in for gdb's MI interpreter.
+//
+// A script lists the commands gdb is expected to receive, in order, each
+// followed by the records gdb would answer it with:
+//
+//	# Comments and blank lines are ignored.
+//	=thread-group-added,id="i1"
+//	> run
+//	^running
+//	*running,thread-id="all"
+//	*stopped,reason="breakpoint-hit",bkptno="1",frame={func="main"}
+//	> /^break internal_/
+//	^done
+//	> *
+//	^done
+//
+// Records before the first command are written at startup. A command is
+// matched exactly, or as a regular expression if written as /re/, and *
+// matches any command. Result records (^...) are given the token of the
+// command they answer, so transcripts recorded from gdb, tokens and all,
+// can be replayed as they are. A command which does not match the next
+// one in the script is answered with an error, and the script does not
+// move on.
+package fakemi
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// The prompt gdb prints after every batch of records.
+const prompt = "(gdb) "
+
+// Step is a command expected by a script, and the records answering it.
+type Step struct {
+	Command string
+	pattern *regexp.Regexp
+	Records []string
+}
+
+// Script is a sequence of steps, preceded by records written at startup.
+type Script struct {
+	Banner []string
+	Steps  []Step
+}
+
+var tokenRegexp = regexp.MustCompile(`^[0-9]+`)
+
+// Parse reads a script.
+func Parse(r io.Reader) (*Script, error) {
+	s := new(Script)
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, ">") {
+			step, err := newStep(strings.TrimSpace(strings.TrimPrefix(line, ">")))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", lineNo, err.Error())
+			}
+			s.Steps = append(s.Steps, step)
+			continue
+		}
+
+		if len(s.Steps) == 0 {
+			s.Banner = append(s.Banner, line)
+		} else {
+			last := &s.Steps[len(s.Steps)-1]
+			last.Records = append(last.Records, line)
+		}
+	}
+	return s, scanner.Err()
+}
+
+// ParseFile reads a script from a file.
+func ParseFile(name string) (*Script, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+func newStep(command string) (Step, error) {
+	step := Step{Command: tokenRegexp.ReplaceAllString(command, "")}
+	if len(step.Command) > 1 && strings.HasPrefix(step.Command, "/") && strings.HasSuffix(step.Command, "/") {
+		re, err := regexp.Compile(step.Command[1 : len(step.Command)-1])
+		if err != nil {
+			return step, err
+		}
+		step.pattern = re
+	}
+	return step, nil
+}
+
+func (step Step) matches(command string) bool {
+	if step.pattern != nil {
+		return step.pattern.MatchString(command)
+	}
+	return step.Command == "*" || step.Command == command
+}
+
+// Serve answers the commands read from in as the script says, writing the
+// records to out. It returns when in runs out or gdb is asked to exit.
+func (s *Script) Serve(in io.Reader, out io.Writer) error {
+	w := bufio.NewWriter(out)
+	writeRecords := func(token string, records []string) error {
+		for _, record := range records {
+			record = tokenRegexp.ReplaceAllString(record, "")
+			if strings.HasPrefix(record, "^") {
+				record = token + record
+			}
+			fmt.Fprintln(w, record)
+		}
+		fmt.Fprintln(w, prompt)
+		return w.Flush()
+	}
+
+	if err := writeRecords("", s.Banner); err != nil {
+		return err
+	}
+
+	next := 0
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		token := tokenRegexp.FindString(line)
+		command := strings.TrimPrefix(line, token)
+
+		if command == "-gdb-exit" {
+			return writeRecords(token, []string{"^exit"})
+		}
+
+		var records []string
+		if next < len(s.Steps) && s.Steps[next].matches(command) {
+			records = s.Steps[next].Records
+			next++
+		} else {
+			expected := "nothing"
+			if next < len(s.Steps) {
+				expected = s.Steps[next].Command
+			}
+			msg := fmt.Sprintf("fakemi: unexpected command %s, expected %s", command, expected)
+			records = []string{fmt.Sprintf("^error,msg=%q", msg)}
+		}
+		if err := writeRecords(token, records); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// Main runs a script named in args on the standard input and output, as a
+// replacement for gdb. Any other arguments, like those pd passes to gdb,
+// are ignored. It returns the exit status.
+func Main(args []string) int {
+	if len(args) < 1 {
+		fmt.Fprintln(os.Stderr, "Usage: fakegdb <script> [gdb arguments...]")
+		return 2
+	}
+	script, err := ParseFile(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fakemi: %s\n", err.Error())
+		return 1
+	}
+	if err := script.Serve(os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "fakemi: %s\n", err.Error())
+		return 1
+	}
+	return 0
+}
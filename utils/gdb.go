@@ -1,27 +1,57 @@
 package utils
 
 import (
-	"bufio"
 	// "encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/milindl/gdb"
 )
 
 const MPI_COMM_WORLD int = 1140850688
 
+var _ Debugger = &GdbInstance{}
+
+// GdbInstance is the Debugger backend which drives gdb over the MI
+// interface.
 type GdbInstance struct {
 	breakpointHitNotification chan int
 	pdFilename                string
 	internal                  *gdb.Gdb
-	hooks                     map[string]func(notification map[string]interface{}) bool
+	hooks                     map[string]func(e Event)
 	cInfoChan                 chan CollectiveInfo
+	sInfoChan                 chan StateInfo
 	trackedCollectives        map[string]bool
+
+	// State reporting is switched off while gdb is being initialized and
+	// while an internal collective breakpoint is being processed, so that
+	// the server only hears about stops the user cares about.
+	stateMux       sync.Mutex
+	reportingState bool
+	running        bool
+
+	// core is set when a core file is being debugged, and attached is set
+	// when gdb attached to a running process.
+	core     bool
+	attached bool
+
+	// ignoreStops is set while running commands which stop the inferior
+	// without gdb saying that it is running first, like loading a core
+	// file or attaching. Nobody waits for those stops.
+	ignoreStops bool
+
+	// collectiveReached is set while RunToCollective waits for the inferior
+	// to stop in a collective named stopAtCollective, or in any tracked
+	// collective if it is empty. It gets nil if the inferior stops anywhere
+	// else.
+	collectiveReached chan *CollectiveInfo
+	stopAtCollective  string
 }
 
 type CollectiveInfo struct {
@@ -30,23 +60,76 @@ type CollectiveInfo struct {
 	FunctionName string
 }
 
+// StateInfo describes a change in the execution state of the inferior.
+// State is one of "running", "stopped" or "exited". For stops, Reason is the
+// reason reported by gdb (e.g. "breakpoint-hit", "end-stepping-range").
+type StateInfo struct {
+	Rank     int
+	State    string
+	Reason   string
+	File     string
+	Line     string
+	Function string
+	// Fullname is the absolute path of File, on the node of the rank.
+	Fullname string
+	BkptNo   int
+	ExitCode int
+	Signal   string
+	// Frames holds the backtrace of a crashed rank, innermost frame first.
+	Frames []string
+}
+
+// Signals which terminate the program if it is allowed to continue.
+// SIGTERM and SIGKILL are left out, since those are usually sent by the
+// launcher to clean up the remaining ranks.
+var fatalSignals = map[string]bool{
+	"SIGSEGV": true,
+	"SIGABRT": true,
+	"SIGBUS":  true,
+	"SIGFPE":  true,
+	"SIGILL":  true,
+	"SIGSYS":  true,
+}
+
+// Crashed tells if the state describes a rank that crashed, i.e. it got a
+// fatal signal or called MPI_Abort.
+func (s StateInfo) Crashed() bool {
+	return fatalSignals[s.Signal] || s.Reason == "mpi-abort"
+}
+
 // NewGdb creates a new GdbInstance struct.
-func NewGdb(cInfoChan chan CollectiveInfo) (g *GdbInstance) {
+// Collective calls are reported on cInfoChan and execution state changes
+// on sInfoChan.
+func NewGdb(cInfoChan chan CollectiveInfo, sInfoChan chan StateInfo) (g *GdbInstance, err error) {
+	return NewGdbCmd(nil, cInfoChan, sInfoChan)
+}
+
+// NewGdbCmd is like NewGdb, but runs the given command in place of gdb,
+// e.g. a gdb built elsewhere or fakegdb. If cmd is nil, gdb is run.
+func NewGdbCmd(cmd []string, cInfoChan chan CollectiveInfo, sInfoChan chan StateInfo) (g *GdbInstance, err error) {
 	// start a new instance and pipe the target output to stdout
 	g = new(GdbInstance)
-	g.hooks = make(map[string]func(notification map[string]interface{}) bool)
+	g.hooks = make(map[string]func(e Event))
 	g.breakpointHitNotification = make(chan int)
-	g.internal, _ = gdb.New(g.handleNotifications)
+	if cmd == nil {
+		g.internal, err = gdb.New(g.handleNotifications)
+	} else {
+		g.internal, err = gdb.NewCmd(cmd, g.handleNotifications)
+	}
+	if err != nil {
+		return nil, err
+	}
 	g.cInfoChan = cInfoChan
+	g.sInfoChan = sInfoChan
 	g.trackedCollectives = make(map[string]bool)
 	return
 }
 
-// InitGdb does the following:
+// RunToInit does the following:
 // 1. Mirror stdout of the target program to stdout of the go program as well as the server. (TODO: Echo output of target to server)
 // 2. Add LD_PRELOAD with the shared library file.
-// 3. Run the code uptill MPI_Init, and write data about rank, size to pdFilename.
-func (g *GdbInstance) InitGdb(debugTarget string) (pdFilename string) {
+// 3. Run the code uptill MPI_Init with the given arguments, and read data about rank, size from pdFilename.
+func (g *GdbInstance) RunToInit(debugTarget string, args []string) (rank int, wSize int, err error) {
 	go io.Copy(os.Stdout, g.internal)
 
 	fname := getSoFilepath()
@@ -54,51 +137,439 @@ func (g *GdbInstance) InitGdb(debugTarget string) (pdFilename string) {
 
 	g.SynchronizedSend("set breakpoint pending on")
 	g.SynchronizedSend("file", debugTarget)
+	if len(args) != 0 {
+		g.SynchronizedSend("-exec-arguments", args...)
+	}
 	g.SynchronizedSend(fmt.Sprintf("set exec-wrapper env 'LD_PRELOAD=%s' 'FILENAME=%s'", fname, g.pdFilename))
 	g.SynchronizedSend("break PMPI_Init")
 	g.SynchronizedSend("run")
 	g.SynchronizedSend("finish")
 	g.SynchronizedSend("finish")
 	g.SynchronizedSend("clear PMPI_Init")
+	g.SynchronizedSend("break MPI_Abort")
 
-	g.toggleCollectiveTracking("MPI_Bcast")
-	// g.toggleCollectiveTracking("MPI_Barrier")
+	g.ToggleCollectiveTracking("MPI_Bcast")
+	// g.ToggleCollectiveTracking("MPI_Barrier")
 	// g.SynchronizedSend("break internal_MPI_Barrier")
 	// g.SynchronizedSend("break internal_MPI_Bcast")
 
-	return g.pdFilename
+	data, err := ioutil.ReadFile(g.pdFilename)
+	if err != nil {
+		return -1, -1, err
+	}
+	_, err = fmt.Sscanf(string(data), "%d,%d", &rank, &wSize)
+	return
+}
+
+// LoadCore loads debugTarget along with a core file left behind by it, for
+// postmortem debugging.
+func (g *GdbInstance) LoadCore(debugTarget string, coreFile string) error {
+	go io.Copy(os.Stdout, g.internal)
+
+	g.core = true
+	g.SynchronizedSend("file", debugTarget)
+	// Nothing runs in a core file, so stops are ignored from now on.
+	g.setIgnoreStops(true)
+	return resultError(g.SynchronizedSend("core-file", coreFile))
+}
+
+// Attach attaches to a running process, which is left stopped.
+func (g *GdbInstance) Attach(pid int) error {
+	go io.Copy(os.Stdout, g.internal)
+
+	g.attached = true
+	g.SynchronizedSend("set breakpoint pending on")
+	// gdb reports that the process stopped before it reports that the
+	// attach is done.
+	g.setIgnoreStops(true)
+	err := g.Execute(fmt.Sprintf("attach %d", pid))
+	g.setIgnoreStops(false)
+
+	if err != nil {
+		return fmt.Errorf("could not attach to %d: %s", pid, err.Error())
+	}
+	g.SynchronizedSend("break MPI_Abort")
+	return nil
 }
 
-// This will run indefinitely and process messages from some Reader.
-// This reader will (usually) be the Conn of the server.
-// For each message, it either runs it in the gdb instance (if the message prefix is RUN:)
-// else it prints the message (if the prefix is COMMAND:)
-func (g *GdbInstance) ProcessCommands(r io.Reader, processCommandsDone chan bool) {
-	scanner := bufio.NewScanner(r)
+// MPI_COMM_WORLD of the MPI implementations that EvaluateRank knows of.
+var worldComms = []string{
+	"&ompi_mpi_comm_world", // Open MPI
+	strconv.Itoa(MPI_COMM_WORLD),
+}
 
-	for scanner.Scan() {
-		CheckError(scanner.Err())
-		line := scanner.Text()
-		lineSplit := strings.SplitN(line, ":", 2)
+// EvaluateRank finds out the rank and the world size of the inferior by
+// calling MPI_Comm_rank and MPI_Comm_size in it. MPI must be initialized.
+func (g *GdbInstance) EvaluateRank() (rank int, wSize int, err error) {
+	// Calling functions in the inferior may make gdb report stops which
+	// nobody waits for.
+	g.setIgnoreStops(true)
+	defer g.setIgnoreStops(false)
 
-		if len(lineSplit) != 2 {
+	g.SynchronizedSend("set var $pd_buf = (int *) malloc(sizeof(int))")
+	defer g.SynchronizedSend("call (void) free($pd_buf)")
+
+	for _, comm := range worldComms {
+		rank, err = g.evaluateCommCall("MPI_Comm_rank", comm)
+		if err != nil {
 			continue
 		}
+		wSize, err = g.evaluateCommCall("MPI_Comm_size", comm)
+		if err == nil {
+			return
+		}
+	}
+	return -1, -1, fmt.Errorf("could not evaluate the rank: %s", err.Error())
+}
+
+// evaluateCommCall calls fn(comm, $pd_buf) in the inferior, and gives back
+// the integer left in $pd_buf.
+func (g *GdbInstance) evaluateCommCall(fn string, comm string) (int, error) {
+	call := fmt.Sprintf("((int (*)(void *, int *)) %s)((void *) %s, $pd_buf)", fn, comm)
+	value, err := g.Evaluate(call)
+	if err != nil {
+		return -1, err
+	}
+	if value != "0" {
+		return -1, fmt.Errorf("%s returned %s", fn, value)
+	}
+	value, err = g.Evaluate("*$pd_buf")
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(value)
+}
+
+// Execute runs a command and waits for it to complete.
+func (g *GdbInstance) Execute(command string) error {
+	return resultError(g.SynchronizedSend(command))
+}
+
+// Evaluate evaluates an expression in the current frame of the inferior.
+func (g *GdbInstance) Evaluate(expression string) (string, error) {
+	result := g.SynchronizedSend("-data-evaluate-expression", expression)
+	if err := resultError(result); err != nil {
+		return "", err
+	}
+	payload, _ := result["payload"].(map[string]interface{})
+	value, _ := payload["value"].(string)
+	return value, nil
+}
+
+// Stack gives the frames of the current thread, innermost first.
+func (g *GdbInstance) Stack() ([]Frame, error) {
+	result := g.SynchronizedSend("-stack-list-frames")
+	if err := resultError(result); err != nil {
+		return nil, err
+	}
+
+	var frames []Frame
+	payload, _ := result["payload"].(map[string]interface{})
+	stack, _ := payload["stack"].([]interface{})
+	for _, top_ := range stack {
+		top, _ := top_.(map[string]interface{})
+		frame, ok := top["frame"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		f := Frame{}
+		f.File, f.Line, f.Function = frameLocation(frame)
+		f.Fullname, _ = frame["fullname"].(string)
+		level, _ := frame["level"].(string)
+		f.Level, _ = strconv.Atoi(level)
+		frames = append(frames, f)
+	}
+	return frames, nil
+}
+
+// Locals gives the arguments and local variables of the current frame.
+func (g *GdbInstance) Locals() ([]Variable, error) {
+	result := g.SynchronizedSend("-stack-list-variables", "--all-values")
+	if err := resultError(result); err != nil {
+		return nil, err
+	}
+
+	var vars []Variable
+	payload, _ := result["payload"].(map[string]interface{})
+	variables, _ := payload["variables"].([]interface{})
+	for _, variable_ := range variables {
+		variable, _ := variable_.(map[string]interface{})
+		name, _ := variable["name"].(string)
+		value, _ := variable["value"].(string)
+		vars = append(vars, Variable{Name: name, Value: value})
+	}
+	return vars, nil
+}
+
+// Symbols gives the names of the functions and variables of the program,
+// and of the MPI functions, which have no debug information. The other
+// symbols without it are left out, as those of libc and the MPI library
+// alone would be many thousands.
+func (g *GdbInstance) Symbols() ([]string, error) {
+	seen := make(map[string]bool)
+	var names []string
+	for _, command := range []string{"-symbol-info-functions", "-symbol-info-variables"} {
+		result := g.SynchronizedSend(command, "--include-nondebug")
+		if err := resultError(result); err != nil {
+			return nil, err
+		}
+		payload, _ := result["payload"].(map[string]interface{})
+		symbols, _ := payload["symbols"].(map[string]interface{})
+
+		var found []interface{}
+		debug, _ := symbols["debug"].([]interface{})
+		for _, file_ := range debug {
+			file, _ := file_.(map[string]interface{})
+			inFile, _ := file["symbols"].([]interface{})
+			found = append(found, inFile...)
+		}
+		nondebugging, _ := symbols["nondebugging"].([]interface{})
+		for _, symbol_ := range nondebugging {
+			symbol, _ := symbol_.(map[string]interface{})
+			name, _ := symbol["name"].(string)
+			if strings.HasPrefix(name, "MPI_") || strings.HasPrefix(name, "PMPI_") {
+				found = append(found, symbol)
+			}
+		}
+
+		for _, symbol_ := range found {
+			symbol, _ := symbol_.(map[string]interface{})
+			name, _ := symbol["name"].(string)
+			if name != "" && !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	return names, nil
+}
+
+// SourceFiles gives the source files of the program, both as they were
+// named when compiling and as full paths.
+func (g *GdbInstance) SourceFiles() ([]string, error) {
+	result := g.SynchronizedSend("-file-list-exec-source-files")
+	if err := resultError(result); err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var files []string
+	payload, _ := result["payload"].(map[string]interface{})
+	list, _ := payload["files"].([]interface{})
+	for _, file_ := range list {
+		file, _ := file_.(map[string]interface{})
+		for _, key := range []string{"file", "fullname"} {
+			name, _ := file[key].(string)
+			if name != "" && !seen[name] {
+				seen[name] = true
+				files = append(files, name)
+			}
+		}
+	}
+	return files, nil
+}
+
+// InsertBreakpoint inserts a breakpoint, which may be pending if the
+// location is not known yet. As with break, the location may be followed
+// by a condition, e.g. test.c:12 if i == 3.
+func (g *GdbInstance) InsertBreakpoint(location string) (Breakpoint, error) {
+	args := []string{"-f"}
+	if i := strings.Index(location, " if "); i >= 0 {
+		args = append(args, "-c", strings.TrimSpace(location[i+len(" if "):]))
+		location = strings.TrimSpace(location[:i])
+	}
+	result := g.SynchronizedSend("-break-insert", append(args, location)...)
+	if err := resultError(result); err != nil {
+		return Breakpoint{}, err
+	}
+	payload, _ := result["payload"].(map[string]interface{})
+	bkpt, _ := payload["bkpt"].(map[string]interface{})
+	return breakpointFromRecord(bkpt), nil
+}
+
+func (g *GdbInstance) DeleteBreakpoint(number int) error {
+	return resultError(g.SynchronizedSend("-break-delete", strconv.Itoa(number)))
+}
+
+func (g *GdbInstance) EnableBreakpoint(number int, enable bool) error {
+	if enable {
+		return resultError(g.SynchronizedSend("-break-enable", strconv.Itoa(number)))
+	}
+	return resultError(g.SynchronizedSend("-break-disable", strconv.Itoa(number)))
+}
+
+func (g *GdbInstance) setIgnoreStops(ignore bool) {
+	g.stateMux.Lock()
+	g.ignoreStops = ignore
+	g.stateMux.Unlock()
+}
+
+func (g *GdbInstance) isIgnoringStops() bool {
+	g.stateMux.Lock()
+	defer g.stateMux.Unlock()
+	return g.ignoreStops
+}
+
+// ReportState enables reporting of execution state changes on the state
+// channel, and immediately reports where the inferior currently is.
+// It must be called once someone is reading from the state channel.
+func (g *GdbInstance) ReportState() {
+	g.setReportingState(true)
+	result := g.SynchronizedSend("-stack-info-frame")
+	s := StateInfo{State: "stopped", Reason: "initialized"}
+	if payload, ok := result["payload"].(map[string]interface{}); ok {
+		if frame, ok := payload["frame"].(map[string]interface{}); ok {
+			s.File, s.Line, s.Function = frameLocation(frame)
+			s.Fullname, _ = frame["fullname"].(string)
+		}
+	}
 
-		if lineSplit[0] == "COMMAND" {
-			fmt.Printf("Server message: %s\n", lineSplit[1])
-		} else if lineSplit[0] == "RUN" {
-			fmt.Printf("Running: %s\n", lineSplit[1])
-			g.SynchronizedSend(lineSplit[1])
-		} else if lineSplit[0] == "COLLECTIVE" {
-			g.toggleCollectiveTracking(lineSplit[1])
+	if g.attached {
+		s.Reason = "attached"
+	}
+	if g.core {
+		s.Reason = "core"
+		s.Signal = g.coreSignal()
+		if s.Crashed() {
+			g.reportCrash(s)
+			return
 		}
 	}
+	g.sInfoChan <- s
+}
+
+// Names of the signals which commonly end up in a core file, by number.
+var coreSignalNames = map[string]string{
+	"3":  "SIGQUIT",
+	"4":  "SIGILL",
+	"5":  "SIGTRAP",
+	"6":  "SIGABRT",
+	"7":  "SIGBUS",
+	"8":  "SIGFPE",
+	"11": "SIGSEGV",
+	"31": "SIGSYS",
+}
+
+// coreSignal gives the name of the signal which caused the core dump, or ""
+// if gdb cannot tell.
+func (g *GdbInstance) coreSignal() string {
+	signo, err := g.Evaluate("$_siginfo.si_signo")
+	if err != nil {
+		return ""
+	}
+	return coreSignalNames[signo]
+}
+
+func (g *GdbInstance) setReportingState(report bool) {
+	g.stateMux.Lock()
+	g.reportingState = report
+	g.stateMux.Unlock()
+}
+
+func (g *GdbInstance) isReportingState() bool {
+	g.stateMux.Lock()
+	defer g.stateMux.Unlock()
+	return g.reportingState
+}
+
+func (g *GdbInstance) setRunning(running bool) {
+	g.stateMux.Lock()
+	g.running = running
+	g.stateMux.Unlock()
+}
+
+func (g *GdbInstance) sendState(s StateInfo) {
+	if g.isReportingState() {
+		g.sInfoChan <- s
+	}
+}
+
+// reportCrash reports a crash along with the backtrace of the crashed rank.
+func (g *GdbInstance) reportCrash(s StateInfo) {
+	if !g.isReportingState() {
+		return
+	}
+	frames, _ := g.Stack()
+	for _, f := range frames {
+		s.Frames = append(s.Frames, f.String())
+	}
+	g.sendState(s)
+}
+
+// Interrupt stops the inferior if it is running, as if Ctrl-C had been
+// pressed in gdb.
+func (g *GdbInstance) Interrupt() {
+	g.stateMux.Lock()
+	running := g.running
+	g.stateMux.Unlock()
+
+	if !running {
+		return
+	}
+	if err := g.internal.Interrupt(); err != nil {
+		log.Printf("Could not interrupt gdb: %s\n", err.Error())
+	}
+}
+
+// RunToCollective resumes the inferior until it calls a tracked collective,
+// or the collective coll if it is not empty, and leaves it stopped there.
+func (g *GdbInstance) RunToCollective(coll string) (CollectiveInfo, error) {
+	if coll != "" && !g.trackedCollectives[coll] {
+		g.ToggleCollectiveTracking(coll)
+		defer g.ToggleCollectiveTracking(coll)
+	}
+	tracking := false
+	for _, tracked := range g.trackedCollectives {
+		tracking = tracking || tracked
+	}
+	if !tracking {
+		return CollectiveInfo{}, fmt.Errorf("no collective is tracked")
+	}
 
-	processCommandsDone <- true
+	reached := make(chan *CollectiveInfo, 1)
+	g.stateMux.Lock()
+	g.collectiveReached = reached
+	g.stopAtCollective = coll
+	g.stateMux.Unlock()
+	defer func() {
+		g.stateMux.Lock()
+		g.collectiveReached = nil
+		g.stateMux.Unlock()
+	}()
+
+	if err := g.Execute("continue"); err != nil {
+		return CollectiveInfo{}, err
+	}
+	// The collectives which are not stopped at are processed and continued
+	// from in the background, so the stop which Execute waited for may not
+	// be the last one.
+	c := <-reached
+	if c == nil {
+		return CollectiveInfo{}, fmt.Errorf("stopped before calling a collective")
+	}
+	return *c, nil
 }
 
-func (g *GdbInstance) toggleCollectiveTracking(coll string) {
+// collectiveStop tells RunToCollective where the inferior stopped, and if
+// it should stay stopped there.
+func (g *GdbInstance) collectiveStop(c *CollectiveInfo) bool {
+	g.stateMux.Lock()
+	defer g.stateMux.Unlock()
+	if g.collectiveReached == nil {
+		return false
+	}
+	if c != nil && g.stopAtCollective != "" && g.stopAtCollective != c.FunctionName {
+		return false
+	}
+	select {
+	case g.collectiveReached <- c:
+	default:
+	}
+	return true
+}
+
+// ToggleCollectiveTracking starts or stops reporting the calls to a
+// collective, using the internal_ function which mpic.so calls on entry.
+func (g *GdbInstance) ToggleCollectiveTracking(coll string) {
 	curr_val, ok := g.trackedCollectives[coll]
 
 	if !ok || !curr_val {
@@ -110,14 +581,14 @@ func (g *GdbInstance) toggleCollectiveTracking(coll string) {
 	}
 }
 
-// On receiving a notification from gdb, the supplied `hook` will be run.
-// Each hook is supposed to take input a notification and return a boolean
-// in case it fails. A hook can have a name, so that hooks can be removed/added.
-func (g *GdbInstance) AddNotificationHook(hookName string, hook func(notification map[string]interface{}) bool) {
+// On receiving a notification from gdb, the supplied `hook` will be run
+// with the corresponding event. A hook can have a name, so that hooks can
+// be removed/added.
+func (g *GdbInstance) AddEventHook(hookName string, hook func(e Event)) {
 	g.hooks[hookName] = hook
 }
 
-func (g *GdbInstance) RemoveNotificationHook(hookName string) {
+func (g *GdbInstance) RemoveEventHook(hookName string) {
 	delete(g.hooks, hookName)
 }
 
@@ -126,6 +597,8 @@ func (g *GdbInstance) handleNotifications(notification map[string]interface{}) {
 		return
 	}
 
+	event := eventFromNotification(notification)
+
 	// Handle Synchronization, in case we get a ^running, we should
 	// wait for *stopped. Otherwise, we should carry on.
 	switch notification["class"] {
@@ -136,23 +609,103 @@ func (g *GdbInstance) handleNotifications(notification map[string]interface{}) {
 		}
 
 	case "stopped":
+		g.setRunning(false)
+		if g.isIgnoringStops() {
+			break
+		}
+		event.Kind = StoppedEvent
 		g.breakpointHitNotification <- 1
-		isBkpt, funcName, _ := analyzeStoppedProcess(notification["payload"].(map[string]interface{}))
+		payload := notification["payload"].(map[string]interface{})
+		isBkpt, funcName, _ := analyzeStoppedProcess(payload)
+		if isBkpt && strings.HasPrefix(funcName, "internal_") {
+			// processBkpt resumes reporting once it is done with the
+			// internal breakpoint.
+			g.setReportingState(false)
+		} else if g.isReportingState() {
+			// Stops while an internal breakpoint is processed are not
+			// where the inferior ends up.
+			g.collectiveStop(nil)
+		}
+		state := stateFromStopped(payload)
+		event.State = &state
+		if isBkpt && (funcName == "MPI_Abort" || funcName == "PMPI_Abort") {
+			state.Reason = "mpi-abort"
+		}
+		if state.Crashed() && state.State == "stopped" {
+			// The backtrace can only be fetched once this notification
+			// has been handled.
+			go g.reportCrash(state)
+		} else {
+			g.sendState(state)
+		}
 		if isBkpt {
 			go g.processBkpt(funcName)
 		}
 	}
 
+	if notification["type"] == "exec" && notification["class"] == "running" {
+		g.setRunning(true)
+		g.sendState(StateInfo{State: "running"})
+	}
+
 	// Run any custom hooks
 	for _, hook := range g.hooks {
-		// We don't really use the bool returned anywhere yet.
-		hook(notification)
+		hook(event)
+	}
+}
+
+// eventFromNotification translates a notification from gdb into an Event.
+// The details of stops are filled in by handleNotifications.
+func eventFromNotification(notification map[string]interface{}) Event {
+	e := Event{Kind: OtherEvent, Record: notification}
+	switch {
+	case notification["type"] == "console":
+		e.Kind = ConsoleEvent
+		e.Text, _ = notification["payload"].(string)
+	case notification["class"] == "error":
+		e.Kind = ErrorEvent
+		payload, _ := notification["payload"].(map[string]interface{})
+		e.Text, _ = payload["msg"].(string)
+	case notification["type"] == "exec" && notification["class"] == "running":
+		e.Kind = RunningEvent
+	case notification["class"] == "breakpoint-modified":
+		payload, _ := notification["payload"].(map[string]interface{})
+		if bkpt, ok := payload["bkpt"].(map[string]interface{}); ok {
+			b := breakpointFromRecord(bkpt)
+			e.Kind = BreakpointEvent
+			e.Breakpoint = &b
+		}
 	}
+	return e
+}
+
+// resultError gives the error reported in a result record, if any.
+func resultError(result map[string]interface{}) error {
+	if result["class"] != "error" {
+		return nil
+	}
+	payload, _ := result["payload"].(map[string]interface{})
+	msg, _ := payload["msg"].(string)
+	return fmt.Errorf("%s", msg)
+}
+
+// breakpointFromRecord builds a Breakpoint from an MI bkpt tuple.
+func breakpointFromRecord(bkpt map[string]interface{}) (b Breakpoint) {
+	number, _ := bkpt["number"].(string)
+	b.Number, _ = strconv.Atoi(number)
+	b.File, b.Line, b.Function = frameLocation(bkpt)
+	_, b.Pending = bkpt["pending"]
+	b.Enabled = bkpt["enabled"] == "y"
+	times, _ := bkpt["times"].(string)
+	b.Hits, _ = strconv.Atoi(times)
+	return
 }
 
 func (g *GdbInstance) processBkpt(funcName string) {
 	if strings.HasPrefix(funcName, "internal_") {
+		defer g.setReportingState(true)
 		if tracking, exists := g.trackedCollectives[strings.TrimPrefix(funcName, "internal_")]; !exists || !tracking {
+			g.collectiveStop(nil)
 			return
 		}
 		g.SynchronizedSend("finish")
@@ -162,6 +715,7 @@ func (g *GdbInstance) processBkpt(funcName string) {
 		rank_s, _ := extractVariableFromResult(result, "rank")
 		rank, _ := strconv.Atoi(rank_s)
 		if comm != MPI_COMM_WORLD {
+			g.collectiveStop(nil)
 			return
 		}
 		result = g.SynchronizedSend("-stack-list-frames")
@@ -171,6 +725,17 @@ func (g *GdbInstance) processBkpt(funcName string) {
 			strings.TrimPrefix(funcName, "internal_"),
 		}
 		g.cInfoChan <- c
+		g.setReportingState(true)
+		if g.collectiveStop(&c) {
+			// Report the stop which was held back, at the call of the
+			// collective.
+			s := StateInfo{State: "stopped", Reason: "collective", Function: c.FunctionName}
+			if frames, err := g.Stack(); err == nil && len(frames) > 1 {
+				s.File, s.Line, s.Fullname = frames[1].File, frames[1].Line, frames[1].Fullname
+			}
+			g.sendState(s)
+			return
+		}
 		g.SynchronizedSend("continue")
 	}
 }
@@ -224,6 +789,48 @@ func analyzeStoppedProcess(payload map[string]interface{}) (isBkpt bool, funcNam
 	return
 }
 
+// stateFromStopped builds a StateInfo from the payload of a *stopped record.
+func stateFromStopped(payload map[string]interface{}) (s StateInfo) {
+	s.State = "stopped"
+	s.Reason, _ = payload["reason"].(string)
+
+	s.Signal, _ = payload["signal-name"].(string)
+
+	switch s.Reason {
+	case "exited-signalled":
+		s.State = "exited"
+		return
+	case "exited-normally":
+		s.State = "exited"
+		return
+	case "exited":
+		s.State = "exited"
+		code, _ := payload["exit-code"].(string)
+		// gdb reports the exit code in octal.
+		exitCode, _ := strconv.ParseInt(code, 8, 32)
+		s.ExitCode = int(exitCode)
+		return
+	}
+
+	if frame, ok := payload["frame"].(map[string]interface{}); ok {
+		s.File, s.Line, s.Function = frameLocation(frame)
+		s.Fullname, _ = frame["fullname"].(string)
+	}
+	if bkptno, ok := payload["bkptno"].(string); ok {
+		s.BkptNo, _ = strconv.Atoi(bkptno)
+	}
+	return
+}
+
+// frameLocation extracts file, line and function from an MI frame tuple.
+// Any of them may be empty if gdb has no debug information for the frame.
+func frameLocation(frame map[string]interface{}) (file string, line string, function string) {
+	file, _ = frame["file"].(string)
+	line, _ = frame["line"].(string)
+	function, _ = frame["func"].(string)
+	return
+}
+
 func extractVariableFromResult(result map[string]interface{}, varname string) (string, bool) {
 	payload := result["payload"].(map[string]interface{})
 	variables := payload["variables"].([]interface{})
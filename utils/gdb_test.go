@@ -0,0 +1,255 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"git.cse.iitk.ac.in/ssaha/parallel-debugger/utils/fakemi"
+)
+
+func TestMain(m *testing.M) {
+	// The test binary doubles as the fake gdb started by newFakeGdb.
+	if script := os.Getenv("PD_FAKEMI_SCRIPT"); script != "" {
+		os.Exit(fakemi.Main([]string{script}))
+	}
+	os.Exit(m.Run())
+}
+
+// fakeGdb is a GdbInstance talking to a fake gdb which replays a script
+// from testdata, along with what it reported.
+type fakeGdb struct {
+	*GdbInstance
+	collectives chan CollectiveInfo
+	states      chan StateInfo
+}
+
+func newFakeGdb(t *testing.T, script string) *fakeGdb {
+	path, err := filepath.Abs(filepath.Join("testdata", script))
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("PD_FAKEMI_SCRIPT", path)
+	defer os.Unsetenv("PD_FAKEMI_SCRIPT")
+
+	f := &fakeGdb{
+		collectives: make(chan CollectiveInfo),
+		states:      make(chan StateInfo, 64),
+	}
+	sInfoChan := make(chan StateInfo)
+	f.GdbInstance, err = NewGdbCmd([]string{os.Args[0]}, f.collectives, sInfoChan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Reporting a state blocks until it is read, so read them right away.
+	go func() {
+		for s := range sInfoChan {
+			f.states <- s
+		}
+	}()
+	return f
+}
+
+func (f *fakeGdb) expectState(t *testing.T, want StateInfo) {
+	t.Helper()
+	select {
+	case got := <-f.states:
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got state %+v, want %+v", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for state %+v", want)
+	}
+}
+
+func TestExecuteWaitsForStop(t *testing.T) {
+	f := newFakeGdb(t, "step.mi")
+
+	f.ReportState()
+	f.expectState(t, StateInfo{State: "stopped", Reason: "initialized", File: "test.c", Line: "8", Function: "main", Fullname: "/tmp/test.c"})
+
+	if err := f.Execute("next"); err != nil {
+		t.Fatalf("next failed: %s", err.Error())
+	}
+	f.expectState(t, StateInfo{State: "running"})
+	f.expectState(t, StateInfo{State: "stopped", Reason: "end-stepping-range", File: "test.c", Line: "9", Function: "main", Fullname: "/tmp/test.c"})
+
+	err := f.Execute("bogus")
+	if err == nil || !strings.Contains(err.Error(), "Undefined command") {
+		t.Errorf("got error %v for an undefined command", err)
+	}
+}
+
+func TestCollectiveTracking(t *testing.T) {
+	f := newFakeGdb(t, "collective.mi")
+
+	f.ReportState()
+	f.expectState(t, StateInfo{State: "stopped", Reason: "initialized", File: "test.c", Line: "8", Function: "main", Fullname: "/tmp/test.c"})
+
+	f.ToggleCollectiveTracking("MPI_Bcast")
+	if err := f.Execute("continue"); err != nil {
+		t.Fatalf("continue failed: %s", err.Error())
+	}
+
+	select {
+	case got := <-f.collectives:
+		want := CollectiveInfo{Rank: 1, LineInfo: "test.c:12", FunctionName: "MPI_Bcast"}
+		if got != want {
+			t.Errorf("got collective %+v, want %+v", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the collective")
+	}
+
+	// The stops in internal_MPI_Bcast and after finishing it are not
+	// reported, since pd continues past them by itself.
+	f.expectState(t, StateInfo{State: "running"})
+	f.expectState(t, StateInfo{State: "running"})
+	f.expectState(t, StateInfo{State: "exited", Reason: "exited-normally"})
+}
+
+func TestRunToCollective(t *testing.T) {
+	f := newFakeGdb(t, "runtocoll.mi")
+
+	f.ReportState()
+	f.expectState(t, StateInfo{State: "stopped", Reason: "initialized", File: "test.c", Line: "8", Function: "main", Fullname: "/tmp/test.c"})
+
+	type result struct {
+		c   CollectiveInfo
+		err error
+	}
+	done := make(chan result)
+	go func() {
+		c, err := f.RunToCollective("MPI_Bcast")
+		done <- result{c, err}
+	}()
+
+	want := CollectiveInfo{Rank: 1, LineInfo: "test.c:12", FunctionName: "MPI_Bcast"}
+	select {
+	case got := <-f.collectives:
+		if got != want {
+			t.Errorf("got collective %+v, want %+v", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the collective")
+	}
+
+	f.expectState(t, StateInfo{State: "running"})
+	f.expectState(t, StateInfo{State: "stopped", Reason: "collective", File: "test.c", Line: "12", Function: "MPI_Bcast", Fullname: "/tmp/test.c"})
+
+	select {
+	case r := <-done:
+		if r.err != nil || r.c != want {
+			t.Errorf("got %+v, %v from RunToCollective, want %+v", r.c, r.err, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for RunToCollective")
+	}
+}
+
+func TestSymbols(t *testing.T) {
+	f := newFakeGdb(t, "symbols.mi")
+
+	symbols, err := f.Symbols()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"main", "crash", "MPI_Bcast", "PMPI_Bcast", "counter"}; !reflect.DeepEqual(symbols, want) {
+		t.Errorf("got symbols %q, want %q", symbols, want)
+	}
+
+	files, err := f.SourceFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"test.c", "/tmp/test.c", "/usr/include/stdio.h"}; !reflect.DeepEqual(files, want) {
+		t.Errorf("got files %q, want %q", files, want)
+	}
+}
+
+func TestPendingBreakpoint(t *testing.T) {
+	f := newFakeGdb(t, "pending.mi")
+	modified := make(chan Breakpoint, 1)
+	f.AddEventHook("breakpoints", func(e Event) {
+		if e.Kind == BreakpointEvent {
+			modified <- *e.Breakpoint
+		}
+	})
+
+	b, err := f.InsertBreakpoint("solver_step")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Breakpoint{Number: 2, Pending: true, Enabled: true}); b != want {
+		t.Errorf("got breakpoint %+v, want %+v", b, want)
+	}
+
+	if err := f.Execute("continue"); err != nil {
+		t.Fatal(err)
+	}
+	want := Breakpoint{Number: 2, Function: "solver_step", File: "solver.c", Line: "12", Enabled: true}
+	select {
+	case got := <-modified:
+		if got != want {
+			t.Errorf("got modified breakpoint %+v, want %+v", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the breakpoint to be set")
+	}
+}
+
+func TestConditionalBreakpoint(t *testing.T) {
+	f := newFakeGdb(t, "condition.mi")
+
+	b, err := f.InsertBreakpoint("solver.c:120 if iter == 3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Breakpoint{Number: 3, Function: "solve", File: "solver.c", Line: "120", Enabled: true}); b != want {
+		t.Errorf("got breakpoint %+v, want %+v", b, want)
+	}
+}
+
+func TestLocals(t *testing.T) {
+	f := newFakeGdb(t, "locals.mi")
+
+	locals, err := f.Locals()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Variable{{"argc", "1"}, {"p", "{x = 1, y = 2}"}, {"buf", `"hi"`}}
+	if !reflect.DeepEqual(locals, want) {
+		t.Errorf("got locals %+v, want %+v", locals, want)
+	}
+}
+
+func TestCrashReport(t *testing.T) {
+	f := newFakeGdb(t, "crash.mi")
+
+	f.ReportState()
+	f.expectState(t, StateInfo{State: "stopped", Reason: "initialized", File: "test.c", Line: "8", Function: "main", Fullname: "/tmp/test.c"})
+
+	if err := f.Execute("continue"); err != nil {
+		t.Fatalf("continue failed: %s", err.Error())
+	}
+	f.expectState(t, StateInfo{State: "running"})
+	f.expectState(t, StateInfo{
+		State:    "stopped",
+		Reason:   "signal-received",
+		File:     "test.c",
+		Line:     "20",
+		Function: "crash",
+		Fullname: "/tmp/test.c",
+		Signal:   "SIGSEGV",
+		Frames:   []string{"crash at test.c:20", "main at test.c:30"},
+	})
+
+	if err := f.Execute("continue"); err != nil {
+		t.Fatalf("continue failed: %s", err.Error())
+	}
+	f.expectState(t, StateInfo{State: "running"})
+	f.expectState(t, StateInfo{State: "exited", Reason: "exited-signalled", Signal: "SIGSEGV"})
+}
@@ -0,0 +1,214 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// AnySource is the Peer of a receive from MPI_ANY_SOURCE.
+	AnySource = -1
+	// UnknownPeer is the Peer of a call whose peer could not be evaluated.
+	UnknownPeer = -2
+)
+
+// MPICall is a call to a blocking MPI function.
+type MPICall struct {
+	Function string
+	// Collective tells if every rank must make the call for it to return.
+	// Otherwise Peer is the rank it waits for, taken to be a rank in
+	// MPI_COMM_WORLD.
+	Collective bool
+	Peer       int
+}
+
+func (c MPICall) String() string {
+	switch {
+	case c.Collective:
+		return fmt.Sprintf("waiting in %s for the other ranks", c.Function)
+	case c.Peer == AnySource:
+		return fmt.Sprintf("waiting in %s for any rank", c.Function)
+	case c.Peer == UnknownPeer:
+		return fmt.Sprintf("waiting in %s", c.Function)
+	}
+	return fmt.Sprintf("waiting in %s for rank %d", c.Function, c.Peer)
+}
+
+// blockingCalls holds the blocking MPI functions, with the index of the
+// argument holding the peer of those which are not collective.
+var blockingCalls = map[string]struct {
+	collective bool
+	peerArg    int
+}{
+	"MPI_Send":      {false, 3},
+	"MPI_Ssend":     {false, 3},
+	"MPI_Bsend":     {false, 3},
+	"MPI_Rsend":     {false, 3},
+	"MPI_Recv":      {false, 3},
+	"MPI_Probe":     {false, 0},
+	"MPI_Sendrecv":  {false, 8},
+	"MPI_Barrier":   {true, 0},
+	"MPI_Bcast":     {true, 0},
+	"MPI_Reduce":    {true, 0},
+	"MPI_Allreduce": {true, 0},
+	"MPI_Gather":    {true, 0},
+	"MPI_Gatherv":   {true, 0},
+	"MPI_Scatter":   {true, 0},
+	"MPI_Scatterv":  {true, 0},
+	"MPI_Allgather": {true, 0},
+	"MPI_Alltoall":  {true, 0},
+	"MPI_Scan":      {true, 0},
+}
+
+// MatchingCalls gives the functions a peer may call to let a call return.
+func MatchingCalls(function string) []string {
+	switch function {
+	case "MPI_Recv", "MPI_Probe":
+		return []string{"MPI_Send", "MPI_Ssend", "MPI_Bsend", "MPI_Rsend", "MPI_Sendrecv"}
+	case "MPI_Send", "MPI_Ssend", "MPI_Bsend", "MPI_Rsend":
+		return []string{"MPI_Recv", "MPI_Sendrecv"}
+	case "MPI_Sendrecv":
+		return []string{"MPI_Send", "MPI_Ssend", "MPI_Bsend", "MPI_Rsend", "MPI_Sendrecv"}
+	}
+	return []string{function}
+}
+
+var mpiCallPattern = regexp.MustCompile(`\bP?(MPI_[A-Za-z]+)\s*\(`)
+
+// BlockingCall finds a call to a blocking MPI function on the line where
+// the program is stopped, and evaluates its peer. It gives back nil if
+// there is none.
+func BlockingCall(d Debugger) (*MPICall, error) {
+	frames, err := d.Stack()
+	if err != nil {
+		return nil, err
+	}
+	if len(frames) == 0 || frames[0].Fullname == "" {
+		return nil, nil
+	}
+	line, err := strconv.Atoi(frames[0].Line)
+	if err != nil {
+		return nil, nil
+	}
+	source, err := sourceFrom(frames[0].Fullname, line)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, match := range mpiCallPattern.FindAllStringSubmatchIndex(source, -1) {
+		function := source[match[2]:match[3]]
+		call, ok := blockingCalls[function]
+		if !ok {
+			continue
+		}
+		c := &MPICall{Function: function, Collective: call.collective, Peer: UnknownPeer}
+		if call.collective {
+			return c, nil
+		}
+		args := splitArguments(source[match[1]:])
+		if call.peerArg >= len(args) {
+			return c, nil
+		}
+		peer := strings.TrimSpace(args[call.peerArg])
+		if peer == "MPI_ANY_SOURCE" {
+			c.Peer = AnySource
+			return c, nil
+		}
+		if value, err := d.Evaluate(peer); err == nil {
+			if n, err := strconv.Atoi(value); err == nil {
+				c.Peer = n
+			}
+		}
+		return c, nil
+	}
+	return nil, nil
+}
+
+// sourceFrom reads a statement starting at a line of a file, which is the
+// line along with the following ones up to where its parentheses balance.
+func sourceFrom(path string, line int) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	source := ""
+	depth := 0
+	for n := 1; scanner.Scan(); n++ {
+		if n < line {
+			continue
+		}
+		text := scanner.Text()
+		source += text + "\n"
+		depth += strings.Count(text, "(") - strings.Count(text, ")")
+		if depth <= 0 || n >= line+10 {
+			break
+		}
+	}
+	return source, scanner.Err()
+}
+
+// splitArguments splits the arguments of a call, given what follows its
+// opening parenthesis.
+func splitArguments(s string) (args []string) {
+	depth := 0
+	start := 0
+	for i, c := range s {
+		switch c {
+		case '(', '[', '{':
+			depth++
+		case ']', '}':
+			depth--
+		case ')':
+			if depth == 0 {
+				return append(args, s[start:i])
+			}
+			depth--
+		case ',':
+			if depth == 0 {
+				args = append(args, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(args, s[start:])
+}
+
+// AdvanceTo resumes the program until it calls one of functions, and gives
+// back where it stopped.
+func AdvanceTo(d Debugger, functions []string) (Frame, error) {
+	var inserted []Breakpoint
+	for _, function := range functions {
+		b, err := d.InsertBreakpoint(function)
+		if err != nil {
+			continue
+		}
+		inserted = append(inserted, b)
+	}
+	defer func() {
+		for _, b := range inserted {
+			d.DeleteBreakpoint(b.Number)
+		}
+	}()
+	if len(inserted) == 0 {
+		return Frame{}, fmt.Errorf("none of %s is known", strings.Join(functions, ", "))
+	}
+
+	if err := d.Execute("continue"); err != nil {
+		return Frame{}, err
+	}
+	frames, err := d.Stack()
+	if err != nil {
+		return Frame{}, err
+	}
+	if len(frames) == 0 {
+		return Frame{}, fmt.Errorf("the program is not running")
+	}
+	return frames[0], nil
+}
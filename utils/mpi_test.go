@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// stoppedAt is a Debugger stopped at a line, which evaluates every
+// expression to the same value.
+type stoppedAt struct {
+	Debugger
+	frame Frame
+	value string
+}
+
+func (d stoppedAt) Stack() ([]Frame, error) {
+	return []Frame{d.frame}, nil
+}
+
+func (d stoppedAt) Evaluate(expression string) (string, error) {
+	return d.value, nil
+}
+
+func TestBlockingCall(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	source := filepath.Join(dir, "test.c")
+	err = ioutil.WriteFile(source, []byte(`int main() {
+    x = f(rank);
+    MPI_Recv(buf, n, MPI_INT, (rank + 1) % size, 0,
+             MPI_COMM_WORLD, MPI_STATUS_IGNORE);
+    MPI_Recv(buf, n, MPI_INT, MPI_ANY_SOURCE, 0, MPI_COMM_WORLD, &status);
+    PMPI_Bcast(buf, n, MPI_INT, 0, MPI_COMM_WORLD);
+}
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		line string
+		want *MPICall
+	}{
+		{"2", nil},
+		{"3", &MPICall{Function: "MPI_Recv", Peer: 5}},
+		{"5", &MPICall{Function: "MPI_Recv", Peer: AnySource}},
+		{"6", &MPICall{Function: "MPI_Bcast", Collective: true, Peer: UnknownPeer}},
+	}
+	for _, test := range tests {
+		d := stoppedAt{frame: Frame{Fullname: source, Line: test.line}, value: "5"}
+		got, err := BlockingCall(d)
+		if err != nil {
+			t.Fatalf("line %s: %s", test.line, err.Error())
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("line %s: got %+v, want %+v", test.line, got, test.want)
+		}
+	}
+}
+
+func TestSplitArguments(t *testing.T) {
+	got := splitArguments(`buf, f(a, b), c[1,2], "x") + 1;`)
+	want := []string{"buf", " f(a, b)", " c[1,2]", ` "x"`}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}